@@ -0,0 +1,122 @@
+package vars
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Collector is a source of metrics, such as runtime or process
+// statistics, that updates a Metrics when asked to, rather than
+// continuously.
+type Collector interface {
+	// Collect sets the current values of the metrics of the
+	// collector in m. It may read m, but it must not call the
+	// methods of m that collect it.
+	Collect(m *Metrics) error
+}
+
+// CollectorFunc adapts an ordinary function to a Collector.
+type CollectorFunc func(m *Metrics) error
+
+// Collect calls f(m).
+func (f CollectorFunc) Collect(m *Metrics) error {
+	return f(m)
+}
+
+// AddCollector registers c to update m each time m is collected.
+// Besides by Collect and CollectEvery, m is collected by Snap and by
+// each of its outputs: String, MarshalJSON, WritePrometheus,
+// DumpMDTable and its variants, and so by every format of Handler.
+// These ignore the errors of the collectors, which are returned by
+// Collect. Since the outputs may be used concurrently, for example,
+// by simultaneous HTTP requests, c may be called concurrently, and it
+// must be safe for that.
+func (m *Metrics) AddCollector(c Collector) error {
+	if m == nil || c == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	m.collectors = append(m.collectors, c)
+	return nil
+}
+
+// Collect calls each of the collectors of m, in the order they were
+// added, and returns the errors they return joined, as by
+// errors.Join. As for all callbacks, the collectors are called
+// without holding any lock of m.
+func (m *Metrics) Collect() error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.RLock()
+	cs := m.collectors
+	m.mu.RUnlock()
+	var errs []error
+	for _, c := range cs {
+		if err := c.Collect(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setCollected sets metric k to v, as a collector of this package,
+// along with the non-empty fields of meta. Since the collector sets k
+// afresh before each output, outputs that select ResetOnRead leave it
+// unchanged.
+func (m *Metrics) setCollected(k string, v interface{}, meta Meta) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if err := m.set(k, v); err != nil {
+		return err
+	}
+	if m.meta == nil {
+		m.meta = make(map[string]Meta)
+	}
+	d := m.meta[k]
+	if meta.Kind != "" {
+		d.Kind = meta.Kind
+	}
+	if meta.Help != "" {
+		d.Help = meta.Help
+	}
+	if meta.Unit != "" {
+		d.Unit = meta.Unit
+	}
+	m.meta[k] = d
+	if m.collected == nil {
+		m.collected = make(map[string]bool)
+	}
+	m.collected[k] = true
+	return nil
+}
+
+// CollectEvery collects m every interval, so its collected metrics
+// stay current for readers that do not snapshot it, until ctx is
+// done. It then returns ctx.Err(). The errors returned by Collect are
+// passed to report, which may be nil.
+func (m *Metrics) CollectEvery(ctx context.Context, interval time.Duration, report func(error)) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	if interval <= 0 {
+		return ErrBadStep
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.Collect(); err != nil && report != nil {
+				report(err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
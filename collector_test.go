@@ -0,0 +1,79 @@
+package vars
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	m := New()
+	polls := 0
+	m.AddCollector(CollectorFunc(func(m *Metrics) error {
+		polls++
+		return m.Set("polls", polls)
+	}))
+	errBroken := errors.New("broken probe")
+	m.AddCollector(CollectorFunc(func(*Metrics) error {
+		return errBroken
+	}))
+	if err := m.AddCollector(nil); err != ErrInvalid {
+		t.Errorf("nil collector: got=%v, want=%v", err, ErrInvalid)
+	}
+	if got := m.Snap().Values.Get("polls"); got != 1 {
+		t.Errorf("snapshot: got=%v, want=1", got)
+	}
+	if err := m.Collect(); !errors.Is(err, errBroken) {
+		t.Errorf("Collect: got=%v, want=%v", err, errBroken)
+	}
+	if got := m.Get("polls"); got != 2 {
+		t.Errorf("collected: got=%v, want=2", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reported := make(chan error, 1)
+	go func() {
+		<-reported
+		cancel()
+	}()
+	if err := m.CollectEvery(ctx, time.Millisecond, func(err error) {
+		select {
+		case reported <- err:
+		default:
+		}
+	}); err != context.Canceled {
+		t.Errorf("CollectEvery: got=%v, want=%v", err, context.Canceled)
+	}
+	if err := m.CollectEvery(ctx, 0, nil); err != ErrBadStep {
+		t.Errorf("zero interval: got=%v, want=%v", err, ErrBadStep)
+	}
+}
+
+func TestCollectorOutputs(t *testing.T) {
+	m := New()
+	calls := 0
+	m.AddCollector(CollectorFunc(func(m *Metrics) error {
+		calls++
+		// Iterating m from a collector must not collect it again.
+		m.ForEach(func(string, interface{}) bool { return true })
+		return m.Set("probe", calls)
+	}))
+	m.ForEach(func(string, interface{}) bool { return true })
+	if calls != 0 {
+		t.Errorf("ForEach collected m %d times", calls)
+	}
+	for _, format := range []string{"json", "markdown", "prometheus"} {
+		before := calls
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/?format="+format, nil))
+		if calls != before+1 {
+			t.Errorf("%s: collected %d times, want 1", format, calls-before)
+		}
+		if !strings.Contains(w.Body.String(), "probe") {
+			t.Errorf("%s: output lacks probe: %q", format, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package vars
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteCSV writes the values of vars, as extracted from snaps by
+// ExtractNumbersWithOptions, to w in CSV format. The values of integer
+// metrics are written exactly, even beyond the 2^53 range held exactly
+// by a float64, so large counters, such as byte counts, keep their
+// precision. The first row is a
+// header holding "time" and the names of vars, and each following
+// row holds the number of timeunits since the epoch and the values at
+// that time. If opts selects Transpose, the table is written with one
+// row per metric instead: the first row holds "time" and the times,
+// and each following row holds the name of a metric and its values.
+func WriteCSV(w io.Writer, snaps []*Snapshot, timeunits time.Duration, from, to time.Time, vars []string, opts *WriteOptions) error {
+	lines, exacts, err := extractRows(snaps, timeunits, from, to, vars, opts, true)
+	if err != nil {
+		return err
+	}
+	names := append([]string{"time"}, vars...)
+	cell := func(i, j int) string {
+		if j == 0 {
+			return strconv.FormatFloat(lines[i][0], 'f', -1, 64)
+		}
+		if x := exacts[i][j-1]; x != nil {
+			return opts.text(x)
+		}
+		return opts.text(lines[i][j])
+	}
+	cw := csv.NewWriter(w)
+	if opts.transpose() {
+		for j, name := range names {
+			row := make([]string, 1, len(lines)+1)
+			row[0] = name
+			for i := range lines {
+				row = append(row, cell(i, j))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := cw.Write(names); err != nil {
+			return err
+		}
+		for i := range lines {
+			row := make([]string, len(names))
+			for j := range row {
+				row[j] = cell(i, j)
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,55 @@
+package vars
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteCSV(t *testing.T) {
+	base := time.Unix(1, 0)
+	var snaps []*Snapshot
+	vs := New()
+	for i := 0; i < 3; i++ {
+		vs.Set("a", i)
+		vs.Set("b", 0.5*float64(i))
+		s := vs.Snap()
+		s.When = base.Add(time.Duration(i) * time.Millisecond)
+		snaps = append(snaps, s)
+	}
+	to := base.Add(3 * time.Millisecond)
+	vs2 := []struct {
+		opts *WriteOptions
+		want string
+	}{
+		{nil, "time,a,b\n1000,0,0\n1001,1,0.5\n1002,2,1\n"},
+		{&WriteOptions{Transpose: true}, "time,1000,1001,1002\na,0,1,2\nb,0,0.5,1\n"},
+	}
+	for i, v := range vs2 {
+		var b bytes.Buffer
+		if err := WriteCSV(&b, snaps, time.Millisecond, base, to, []string{"a", "b"}, v.opts); err != nil {
+			t.Fatalf("[%d] WriteCSV failed: %v", i, err)
+		}
+		if got := b.String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+}
+
+func TestWriteCSVExact(t *testing.T) {
+	base := time.Unix(1, 0)
+	var snaps []*Snapshot
+	for i, v := range []interface{}{uint64(1<<63 + 1), int64(1<<53 + 1)} {
+		m := New()
+		m.Set("bytes", v)
+		m.Set("ratio", 0.25)
+		snaps = append(snaps, &Snapshot{When: base.Add(time.Duration(i) * time.Millisecond), Values: m})
+	}
+	var b bytes.Buffer
+	if err := WriteCSV(&b, snaps, time.Millisecond, base, base.Add(2*time.Millisecond), []string{"bytes", "ratio"}, nil); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	if got, want := b.String(), "time,bytes,ratio\n1000,9223372036854775809,0.25\n1001,9007199254740993,0.25\n"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
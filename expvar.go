@@ -0,0 +1,48 @@
+package vars
+
+import (
+	"encoding/json"
+	"expvar"
+)
+
+// PublishExpvar publishes m with the standard expvar package under
+// the given name, so the metrics are also served, as JSON, by the
+// /debug/vars handler. As for expvar.Publish, it panics if name is
+// already in use.
+func (m *Metrics) PublishExpvar(name string) {
+	expvar.Publish(name, m)
+}
+
+// PublishExpvar publishes m with the standard expvar package under
+// the given name. It is the same as m.PublishExpvar(name), for use
+// where a function is more convenient, for example, when publishing
+// several sets of metrics in a loop.
+func PublishExpvar(name string, m *Metrics) {
+	m.PublishExpvar(name)
+}
+
+// FromExpvar returns metrics seeded from the current values of the
+// named expvar variables or, if no names are given, of all of them.
+// Each metric holds the JSON decoded value of its variable, so
+// numbers are float64 values and expvar maps are
+// map[string]interface{} values. Unknown names are skipped.
+func FromExpvar(names ...string) *Metrics {
+	m := New()
+	add := func(kv expvar.KeyValue) {
+		var v interface{}
+		if err := json.Unmarshal([]byte(kv.Value.String()), &v); err != nil {
+			v = kv.Value.String()
+		}
+		m.Set(kv.Key, v)
+	}
+	if len(names) == 0 {
+		expvar.Do(add)
+		return m
+	}
+	for _, name := range names {
+		if v := expvar.Get(name); v != nil {
+			add(expvar.KeyValue{Key: name, Value: v})
+		}
+	}
+	return m
+}
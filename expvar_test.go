@@ -0,0 +1,44 @@
+package vars
+
+import (
+	"expvar"
+	"fmt"
+	"testing"
+)
+
+// expvarRuns numbers the runs of TestExpvar, since expvar names can
+// only be published once per process.
+var expvarRuns int
+
+func TestExpvar(t *testing.T) {
+	expvarRuns++
+	name := func(base string) string {
+		return fmt.Sprintf("%s-%d", base, expvarRuns)
+	}
+	m := New()
+	m.Set("hits", 3)
+	m.PublishExpvar(name("vars-test-metrics"))
+	expvar.NewInt(name("vars-test-int")).Set(7)
+	other := New()
+	other.Set("x", "y")
+	PublishExpvar(name("vars-test-other"), other)
+	if got, want := expvar.Get(name("vars-test-other")).String(), `{"x":"y"}`; got != want {
+		t.Errorf("published: got=%s, want=%s", got, want)
+	}
+
+	got := FromExpvar(name("vars-test-metrics"), name("vars-test-int"), name("vars-test-missing"))
+	if n, err := got.GetNumber(name("vars-test-int")); err != nil || n != 7 {
+		t.Errorf("int: got=%g, %v, want=7", n, err)
+	}
+	if mv, ok := got.Get(name("vars-test-metrics")).(map[string]interface{}); !ok || mv["hits"] != 3.0 {
+		t.Errorf("metrics: got=%#v", got.Get(name("vars-test-metrics")))
+	}
+	if got.Get(name("vars-test-missing")) != nil {
+		t.Error("missing expvar present")
+	}
+	m.Inc("hits")
+	all := FromExpvar()
+	if mv, ok := all.Get(name("vars-test-metrics")).(map[string]interface{}); !ok || mv["hits"] != 4.0 {
+		t.Errorf("live metrics: got=%#v", all.Get(name("vars-test-metrics")))
+	}
+}
@@ -0,0 +1,57 @@
+package vars
+
+// valueFunc is the value of a metric set with SetFunc.
+type valueFunc func() interface{}
+
+// computed is implemented by metric values, such as those set with
+// SetFunc, that are computed each time they are read. Since compute
+// may call functions of the caller, it is never called while holding
+// m.mu.
+type computed interface {
+	compute() interface{}
+}
+
+// compute calls fn.
+func (fn valueFunc) compute() interface{} {
+	return fn()
+}
+
+// SetFunc sets metric k to be computed on demand by fn, for values
+// that are cheap to read but tedious to keep updated, such as the
+// length of a queue. Each time the metric is read, by Get, GetNumber,
+// Snap or any of the outputs of m, fn is called to supply its current
+// value. As for all callbacks, fn is called without holding any lock
+// of m, so it may itself use m. Setting k with any other method
+// replaces fn.
+func (m *Metrics) SetFunc(k string, fn func() interface{}) error {
+	if m == nil || fn == nil {
+		return ErrInvalid
+	}
+	return m.Set(k, valueFunc(fn))
+}
+
+// resolve returns the value of a metric read from Detail, computing
+// it if it is computed, for example, set with SetFunc, or reading it
+// if it is updated through a handle, see Counter. It must be called
+// without holding m.mu.
+func resolve(v interface{}) interface{} {
+	switch x := v.(type) {
+	case computed:
+		return x.compute()
+	case *cell:
+		return x.load()
+	}
+	return v
+}
+
+// resolveFuncs replaces each value of detail by its resolved value,
+// see resolve. It must be called without holding any lock of the
+// Metrics the values were read from.
+func resolveFuncs(detail map[string]interface{}) {
+	for k, v := range detail {
+		switch v.(type) {
+		case computed, *cell:
+			detail[k] = resolve(v)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package vars
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetFunc(t *testing.T) {
+	m := New()
+	if err := m.SetFunc("queue", nil); err != ErrInvalid {
+		t.Errorf("nil func: got=%v, want=%v", err, ErrInvalid)
+	}
+	queue := []int{1, 2}
+	calls := 0
+	m.SetFunc("queue", func() interface{} {
+		calls++
+		// Reading m from the callback must not deadlock.
+		m.Get("other")
+		return len(queue)
+	})
+	if got := m.Get("queue"); got != 2 {
+		t.Errorf("Get: got=%v, want=2", got)
+	}
+	queue = append(queue, 3)
+	if got, err := m.GetNumber("queue"); err != nil || got != 3 {
+		t.Errorf("GetNumber: got=%g, %v, want=3", got, err)
+	}
+	if got := m.Snap().Values.Get("queue"); got != 3 {
+		t.Errorf("Snap: got=%v, want=3", got)
+	}
+	if got, want := m.String(), `{"queue":3}`; got != want {
+		t.Errorf("String: got=%s, want=%s", got, want)
+	}
+	if got := string(m.DumpMDTable()); !strings.Contains(got, "\nqueue | 3\n") {
+		t.Errorf("markdown lacks queue:\n%s", got)
+	}
+	if calls != 5 {
+		t.Errorf("calls: got=%d, want=5", calls)
+	}
+	m.Set("queue", 7)
+	if got := m.Get("queue"); got != 7 || calls != 5 {
+		t.Errorf("replaced: got=%v, calls=%d", got, calls)
+	}
+}
+
+func TestSetFuncReaders(t *testing.T) {
+	m := New()
+	m.SetFunc("depth", func() interface{} { return 4 })
+	m.Set("other", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.WaitForValue(ctx, "depth", func(v interface{}) bool { return v == 4 }); err != nil {
+		t.Errorf("WaitForValue failed: %v", err)
+	}
+	if got := m.InRange(3, 5).Get("depth"); got != 4 {
+		t.Errorf("InRange: got=%v, want=4", got)
+	}
+	if got := fmt.Sprint(m.TopN(1)); got != "[{depth 4}]" {
+		t.Errorf("TopN: got=%s", got)
+	}
+	if err := m.SumKeys("sum", "depth", "other"); err != nil {
+		t.Errorf("SumKeys failed: %v", err)
+	} else if got := m.Get("sum"); got != 5.0 {
+		t.Errorf("SumKeys: got=%v, want=5", got)
+	}
+	dst := make(map[string]interface{})
+	m.DrainInto(dst)
+	if got := dst["depth"]; got != 4 {
+		t.Errorf("DrainInto: got=%#v, want=4", got)
+	}
+}
@@ -0,0 +1,52 @@
+package vars
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Handler returns an http.Handler that serves the current metric
+// values. The format is selected by the "format" query parameter,
+// which is one of "json", "markdown" or "prometheus". Without it, the
+// format is selected by the Accept header of the request: Prometheus
+// text for "text/plain", as requested by Prometheus scrapers,
+// markdown for "text/markdown" and JSON otherwise.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			accept := r.Header.Get("Accept")
+			switch {
+			case strings.Contains(accept, "text/plain"):
+				format = "prometheus"
+			case strings.Contains(accept, "text/markdown"):
+				format = "markdown"
+			default:
+				format = "json"
+			}
+		}
+		var b bytes.Buffer
+		switch format {
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			b.WriteString(m.String())
+		case "markdown":
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			b.Write(m.DumpMDTable())
+		case "prometheus":
+			// Conflicting metrics are skipped, and reported
+			// in the output.
+			if err := m.WritePrometheus(&b, nil); err != nil && !errors.Is(err, ErrConflict) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		default:
+			http.Error(w, "unsupported format "+format, http.StatusBadRequest)
+			return
+		}
+		w.Write(b.Bytes())
+	})
+}
@@ -0,0 +1,47 @@
+package vars
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	h := m.Handler()
+	vs := []struct {
+		target, accept string
+		code           int
+		contentType    string
+		body           string
+	}{
+		{"/", "", http.StatusOK, "application/json", `{"a":1}`},
+		{"/?format=markdown", "", http.StatusOK, "text/markdown; charset=utf-8", "a | 1\n"},
+		{"/", "text/plain;version=0.0.4", http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", "a 1\n"},
+		{"/", "text/markdown", http.StatusOK, "text/markdown; charset=utf-8", "a | 1\n"},
+		{"/?format=xml", "", http.StatusBadRequest, "", ""},
+	}
+	for i, v := range vs {
+		req := httptest.NewRequest("GET", v.target, nil)
+		if v.accept != "" {
+			req.Header.Set("Accept", v.accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != v.code {
+			t.Errorf("[%d] got code=%d, want=%d", i, rec.Code, v.code)
+			continue
+		}
+		if v.code != http.StatusOK {
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); got != v.contentType {
+			t.Errorf("[%d] got content type=%q, want=%q", i, got, v.contentType)
+		}
+		if got := rec.Body.String(); !strings.HasSuffix(got, v.body) {
+			t.Errorf("[%d] got=%q, want suffix %q", i, got, v.body)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package vars
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is a handle for a counter metric, a metric that only
+// increases, except when it is reset.
+type Counter struct {
+	handle
+}
+
+// Gauge is a handle for a gauge metric, a metric that can increase
+// and decrease.
+type Gauge struct {
+	handle
+}
+
+// handle holds the metric updated by a Counter or Gauge. While it is
+// attached, the value of the metric is a cell in Detail, which the
+// handle updates without taking the lock of its Metrics.
+type handle struct {
+	m    *Metrics
+	k    string
+	kind Kind
+	c    atomic.Pointer[cell]
+}
+
+// cell holds the float64 value of a metric updated through a handle.
+type cell struct {
+	bits     atomic.Uint64
+	last     atomic.Int64
+	detached atomic.Bool
+}
+
+// load returns the value of c.
+func (c *cell) load() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// store sets the value of c to v.
+func (c *cell) store(v float64) {
+	c.bits.Store(math.Float64bits(v))
+	c.last.Store(time.Now().UnixNano())
+}
+
+// swap sets the value of c to v and returns its previous value.
+func (c *cell) swap(v float64) float64 {
+	return math.Float64frombits(c.bits.Swap(math.Float64bits(v)))
+}
+
+// add adds n to the value of c, limiting the result to the range
+// [lo, hi].
+func (c *cell) add(n, lo, hi float64) {
+	for {
+		old := c.bits.Load()
+		x := math.Max(lo, math.Min(hi, math.Float64frombits(old)+n))
+		if c.bits.CompareAndSwap(old, math.Float64bits(x)) {
+			break
+		}
+	}
+	c.last.Store(time.Now().UnixNano())
+}
+
+// String formats the value of c, as for a float64 metric.
+func (c *cell) String() string {
+	return strconv.FormatFloat(c.load(), 'g', -1, 64)
+}
+
+// MarshalJSON encodes the value of c, as for a float64 metric.
+func (c *cell) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.load())
+}
+
+// lastActive returns the time of the most recent update of c.
+func (c *cell) lastActive() time.Time {
+	if n := c.last.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// detach marks c as no longer held by its Metrics.
+func (c *cell) detach() {
+	c.detached.Store(true)
+}
+
+// detachable is implemented by metric values, such as cells, that
+// are updated by a value outside of the Metrics holding them, and
+// that need to know when they are no longer held.
+type detachable interface {
+	detach()
+}
+
+// release detaches v from the Metrics that held it, if it is
+// detachable, so whatever updates it attaches it again. It is called
+// whenever a metric value is removed or replaced.
+func release(v interface{}) {
+	if d, ok := v.(detachable); ok {
+		d.detach()
+	}
+}
+
+// attach returns the cell holding metric k, replacing any other value
+// of k with a cell holding its numerical value, or zero, and sets the
+// Kind of k to kind. It fails if the cell cannot be added, see
+// SetMaxKeys.
+func (m *Metrics) attach(k string, kind Kind) (*cell, error) {
+	if m == nil {
+		return nil, ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	c, ok := m.Detail[k].(*cell)
+	if !ok {
+		c = new(cell)
+		if x, err := AsNumber(m.Detail[k]); err == nil {
+			c.bits.Store(math.Float64bits(x))
+		}
+		if err := m.set(k, c); err != nil {
+			return nil, err
+		}
+	}
+	m.applyKind(k, kind)
+	return c, nil
+}
+
+// cell returns the attached cell of h, attaching a new one if its
+// metric was removed or replaced since.
+func (h *handle) cell() (*cell, error) {
+	if c := h.c.Load(); c != nil && !c.detached.Load() {
+		return c, nil
+	}
+	c, err := h.m.attach(h.k, h.kind)
+	if err != nil {
+		return nil, err
+	}
+	h.c.Store(c)
+	return c, nil
+}
+
+// add adds n to the metric of h, if it can be attached.
+func (h *handle) add(n float64) {
+	if c, err := h.cell(); err == nil {
+		c.add(n, math.Inf(-1), math.Inf(1))
+	}
+}
+
+// set sets the metric of h to v.
+func (h *handle) set(v float64) error {
+	c, err := h.cell()
+	if err != nil {
+		return err
+	}
+	c.store(v)
+	return nil
+}
+
+// value returns the value of the metric of h. It does not attach a
+// cell, so reading a metric replaced by a non-numerical value yields
+// zero.
+func (h *handle) value() float64 {
+	if c := h.c.Load(); c != nil && !c.detached.Load() {
+		return c.load()
+	}
+	n, _ := h.m.GetNumber(h.k)
+	return n
+}
+
+// setKind ensures metric k exists, see Touch, and sets the Kind of its
+// descriptive information. If k cannot be created, see SetMaxKeys,
+// nothing is recorded for it.
+func (m *Metrics) setKind(k string, kind Kind) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if _, ok := m.Detail[k]; !ok && m.set(k, float64(0)) != nil {
+		return
+	}
+	m.applyKind(k, kind)
+}
+
+// applyKind sets the Kind of the descriptive information of metric k.
+// The caller must hold m.mu.
+func (m *Metrics) applyKind(k string, kind Kind) {
+	if m.meta == nil {
+		m.meta = make(map[string]Meta)
+	}
+	meta := m.meta[k]
+	meta.Kind = kind
+	m.meta[k] = meta
+}
+
+// Counter returns a handle for the counter metric k of m. The metric
+// is created, with a value of zero, if it does not exist, and its
+// Kind is set to KindCounter, so exporters describe it as a counter.
+//
+// The handle updates the metric directly, without looking it up or
+// taking the lock of m, so it suits hot paths. While it is in use, the
+// metric holds a float64, and setting it to a float64 via m, for
+// example with Reset, keeps it in use. Setting it to any other value,
+// or deleting it, makes the handle recreate it, with its Kind, when
+// next updated.
+// Unlike the methods of m, updates made through the handle do not
+// wake WaitForValue.
+func (m *Metrics) Counter(k string) *Counter {
+	c := &Counter{handle{m: m, k: k, kind: KindCounter}}
+	c.cell()
+	return c
+}
+
+// Gauge returns a handle for the gauge metric k of m. The metric is
+// created, with a value of zero, if it does not exist, and its Kind
+// is set to KindGauge, so exporters describe it as a gauge. The
+// handle updates the metric as described for Counter.
+func (m *Metrics) Gauge(k string) *Gauge {
+	g := &Gauge{handle{m: m, k: k, kind: KindGauge}}
+	g.cell()
+	return g
+}
+
+// Inc adds 1 to the counter.
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+// Add adds n to the counter. Since a counter never decreases, a
+// negative n is ignored.
+func (c *Counter) Add(n float64) {
+	if n > 0 {
+		c.add(n)
+	}
+}
+
+// Set sets the counter to v, for example, to reset it.
+func (c *Counter) Set(v float64) error {
+	return c.set(v)
+}
+
+// Value returns the value of the counter.
+func (c *Counter) Value() float64 {
+	return c.value()
+}
+
+// Inc adds 1 to the gauge.
+func (g *Gauge) Inc() {
+	g.add(1)
+}
+
+// Dec subtracts 1 from the gauge.
+func (g *Gauge) Dec() {
+	g.add(-1)
+}
+
+// Add adds n to the gauge.
+func (g *Gauge) Add(n float64) {
+	g.add(n)
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) error {
+	return g.set(v)
+}
+
+// Value returns the value of the gauge.
+func (g *Gauge) Value() float64 {
+	return g.value()
+}
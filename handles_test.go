@@ -0,0 +1,117 @@
+package vars
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandles(t *testing.T) {
+	m := New()
+	c := m.Counter("requests")
+	g := m.Gauge("inflight")
+	if got := m.Get("requests"); got != 0.0 {
+		t.Errorf("counter not created: got=%v", got)
+	}
+	c.Inc()
+	c.Add(2)
+	c.Add(-5)
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(-3)
+	if got := c.Value(); got != 3 {
+		t.Errorf("counter: got=%g, want=3", got)
+	}
+	if got := g.Value(); got != -2 {
+		t.Errorf("gauge: got=%g, want=-2", got)
+	}
+	g.Set(7)
+	if got := m.Get("inflight"); got != 7.0 {
+		t.Errorf("gauge set: got=%v, want=7", got)
+	}
+	if meta, _ := m.GetMeta("requests"); meta.Kind != KindCounter {
+		t.Errorf("counter kind: got=%q", meta.Kind)
+	}
+	if meta, _ := m.GetMeta("inflight"); meta.Kind != KindGauge {
+		t.Errorf("gauge kind: got=%q", meta.Kind)
+	}
+}
+
+func TestHandleCells(t *testing.T) {
+	m := New()
+	c := m.Counter("requests")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := m.Get("requests"); got != 8000.0 {
+		t.Fatalf("concurrent: got=%v, want=8000", got)
+	}
+	m.Add("requests", 2)
+	if got, want := fmt.Sprint(m.Snap().Values.Get("requests"), c.Value()), "8002 8002"; got != want {
+		t.Errorf("snapshot: got=%s, want=%s", got, want)
+	}
+	d := make(map[string]interface{})
+	m.DrainInto(d)
+	c.Inc()
+	if got, want := fmt.Sprint(d["requests"], m.Get("requests")), "8002 1"; got != want {
+		t.Errorf("drain: got=%s, want=%s", got, want)
+	}
+	if err := m.Reset("requests"); err != nil || c.Value() != 0 {
+		t.Errorf("reset: got=%g, %v", c.Value(), err)
+	}
+	m.Delete("requests")
+	c.Add(3)
+	if got := m.Get("requests"); got != 3.0 {
+		t.Errorf("after Delete: got=%v, want=3", got)
+	}
+	if meta, _ := m.GetMeta("requests"); meta.Kind != KindCounter {
+		t.Errorf("kind after Delete: got=%q", meta.Kind)
+	}
+	if got, want := fmt.Sprint(m.Detail), "map[requests:3]"; got != want {
+		t.Errorf("Detail: got=%s, want=%s", got, want)
+	}
+	if b, err := json.Marshal(m.Detail); err != nil || string(b) != `{"requests":3}` {
+		t.Errorf("Detail JSON: got=%s, %v", b, err)
+	}
+	m.Set("requests", "text")
+	if got := c.Value(); got != 0 {
+		t.Errorf("replaced: got=%g, want=0", got)
+	}
+	c.Inc()
+	if got := m.Get("requests"); got != 1.0 {
+		t.Errorf("reattached: got=%v, want=1", got)
+	}
+	if updated, ok := m.LastUpdated("requests"); !ok || time.Since(updated) > time.Minute {
+		t.Errorf("LastUpdated: got=%v, %v", updated, ok)
+	}
+}
+
+func TestHandleLimit(t *testing.T) {
+	m := New()
+	m.SetMaxKeys(1, OverflowReject)
+	m.Set("a", 1)
+	g := m.Gauge("b")
+	g.Inc()
+	if err := g.Set(2); err != ErrTooManyKeys {
+		t.Errorf("Set: got=%v, want=%v", err, ErrTooManyKeys)
+	}
+	m.Delete("a")
+	if err := g.Set(2); err != nil || m.Get("b") != 2.0 {
+		t.Errorf("Set after Delete: got=%v, %v", m.Get("b"), err)
+	}
+	var nilM *Metrics
+	if err := nilM.Counter("x").Set(1); err != ErrInvalid {
+		t.Errorf("nil Metrics: got=%v, want=%v", err, ErrInvalid)
+	}
+}
@@ -0,0 +1,221 @@
+package vars
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Histogram counts observed values in buckets with configurable upper
+// bounds. It is safe for concurrent use.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	// counts[i] is the number of observations in the bucket with
+	// upper bound bounds[i]. The final count is for observations
+	// greater than all of the bounds.
+	counts []uint64
+	count  uint64
+	sum    float64
+	// last is the time of the most recent observation.
+	last time.Time
+}
+
+// DefaultBuckets are the upper bounds of the buckets of the histograms
+// created by Metrics.Observe. They suit latencies measured in
+// seconds, and are the default buckets of the Prometheus client.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewHistogram returns a Histogram with buckets of the given,
+// inclusive, upper bounds. An implicit final bucket counts the
+// observations that exceed all of the bounds.
+func NewHistogram(bounds ...float64) *Histogram {
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{
+		bounds: b,
+		counts: make([]uint64, len(b)+1),
+	}
+}
+
+// Observe records the value v in the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.count++
+	h.sum += v
+	h.last = time.Now()
+}
+
+// lastActive returns the time of the most recent observation.
+func (h *Histogram) lastActive() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+// Count returns the number of observed values.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Sum returns the sum of the observed values.
+func (h *Histogram) Sum() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+// Buckets returns the upper bounds of the buckets of the histogram,
+// and the number of observations in each bucket. There is one more
+// count than bounds: the final count is of the observations that
+// exceed all of the bounds.
+func (h *Histogram) Buckets() (bounds []float64, counts []uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]float64(nil), h.bounds...), append([]uint64(nil), h.counts...)
+}
+
+// state returns the bucket bounds and counts of the histogram, as for
+// Buckets, and the sum of its observed values, all read together, so
+// they are consistent with each other.
+func (h *Histogram) state() (bounds []float64, counts []uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]float64(nil), h.bounds...), append([]uint64(nil), h.counts...), h.sum
+}
+
+// MarshalJSON encodes the histogram as a JSON object holding its
+// count, sum, bucket bounds and bucket counts, as returned by
+// Buckets.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return json.Marshal(struct {
+		Count  uint64    `json:"count"`
+		Sum    float64   `json:"sum"`
+		Bounds []float64 `json:"bounds"`
+		Counts []uint64  `json:"counts"`
+	}{h.count, h.sum, h.bounds, h.counts})
+}
+
+// String summarizes the histogram for text outputs.
+func (h *Histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fmt.Sprintf("count=%d sum=%g", h.count, h.sum)
+}
+
+// clone returns an independent copy of the histogram.
+func (h *Histogram) clone() *Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &Histogram{
+		bounds: h.bounds,
+		counts: append([]uint64(nil), h.counts...),
+		count:  h.count,
+		sum:    h.sum,
+	}
+}
+
+// Observe records v in the histogram held by metric k. If k does not
+// hold a *Histogram, it is replaced by a new one with DefaultBuckets.
+// If creating k would exceed the limit set with SetMaxKeys, and new
+// metrics are rejected, v is dropped.
+func (m *Metrics) Observe(k string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	h, ok := m.Detail[k].(*Histogram)
+	if !ok {
+		h = NewHistogram(DefaultBuckets...)
+		if m.set(k, h) != nil {
+			h = nil
+		}
+	}
+	m.unlock()
+	if h != nil {
+		h.Observe(v)
+	}
+}
+
+// Histogram returns the histogram held by metric k of m, creating it
+// with buckets of the given upper bounds, or DefaultBuckets if none
+// are given, if k does not hold a *Histogram. The Kind of k is set to
+// KindHistogram, if it is held by m. Observations can be recorded
+// directly with the returned Histogram. If creating k would exceed the
+// limit set with SetMaxKeys, and new metrics are rejected, the
+// returned Histogram is not held by m.
+func (m *Metrics) Histogram(k string, bounds ...float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+	if m == nil {
+		return NewHistogram(bounds...)
+	}
+	m.mu.Lock()
+	h, ok := m.Detail[k].(*Histogram)
+	if !ok {
+		h = NewHistogram(bounds...)
+		ok = m.set(k, h) == nil
+	}
+	if ok {
+		m.applyKind(k, KindHistogram)
+	}
+	m.unlock()
+	return h
+}
+
+// Quantile estimates the q-th quantile, 0 <= q <= 1, of the observed
+// values. As for the Prometheus histogram_quantile() function, it
+// assumes the values are evenly distributed within each bucket, and
+// linearly interpolates within the bucket holding the quantile. The
+// lowest bucket is assumed to start at 0, unless its upper bound is
+// not positive. If the quantile falls in the final bucket, the
+// highest upper bound is returned. With no observations, the result
+// is NaN.
+func (h *Histogram) Quantile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.count == 0 || math.IsNaN(q):
+		return math.NaN()
+	case q < 0:
+		return math.Inf(-1)
+	case q > 1:
+		return math.Inf(1)
+	}
+	rank := q * float64(h.count)
+	var below uint64
+	i := 0
+	for ; i < len(h.bounds); i++ {
+		if float64(below+h.counts[i]) >= rank {
+			break
+		}
+		below += h.counts[i]
+	}
+	if i == len(h.bounds) {
+		if i == 0 {
+			return math.NaN()
+		}
+		return h.bounds[i-1]
+	}
+	end := h.bounds[i]
+	start := 0.0
+	if i > 0 {
+		start = h.bounds[i-1]
+	} else if end <= 0 {
+		return end
+	}
+	if h.counts[i] == 0 {
+		return end
+	}
+	return start + (end-start)*(rank-float64(below))/float64(h.counts[i])
+}
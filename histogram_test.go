@@ -0,0 +1,142 @@
+package vars
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram(10, 1, 5)
+	if got := h.Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("empty histogram: got=%g, want=NaN", got)
+	}
+	for _, v := range []float64{0.5, 2, 3, 4, 6, 7, 8, 9, 9.5, 20} {
+		h.Observe(v)
+	}
+	vs := []struct {
+		q, want float64
+	}{
+		{q: 0, want: 0},
+		{q: 0.1, want: 1},
+		{q: 0.3, want: 1 + 4*2.0/3},
+		{q: 0.5, want: 6},
+		{q: 0.9, want: 10},
+		{q: 0.99, want: 10},
+		{q: -1, want: math.Inf(-1)},
+		{q: 2, want: math.Inf(1)},
+	}
+	for i, v := range vs {
+		if got := h.Quantile(v.q); math.Abs(got-v.want) > 1e-9 && got != v.want {
+			t.Errorf("[%d] Quantile(%g): got=%g, want=%g", i, v.q, got, v.want)
+		}
+	}
+}
+
+func TestMetricsObserve(t *testing.T) {
+	m := New()
+	m.Observe("latency", 0.2)
+	m.Observe("latency", 3)
+	s := m.Snap()
+	m.Observe("latency", 7)
+	h, ok := m.Get("latency").(*Histogram)
+	if !ok {
+		t.Fatalf("got %T, want *Histogram", m.Get("latency"))
+	}
+	if got := h.Count(); got != 3 {
+		t.Errorf("count: got=%d, want=3", got)
+	}
+	if got := h.Sum(); got != 10.2 {
+		t.Errorf("sum: got=%g, want=10.2", got)
+	}
+	if got, want := s.Values.Get("latency").(*Histogram).String(), "count=2 sum=3.2"; got != want {
+		t.Errorf("snapshot: got=%q, want=%q", got, want)
+	}
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	m := New()
+	h := m.Histogram("rpc.latency", 0.1, 1)
+	for _, v := range []float64{0.05, 0.5, 0.5, 2} {
+		h.Observe(v)
+	}
+	if m.Histogram("rpc.latency") != h {
+		t.Error("second Histogram call returned a new histogram")
+	}
+	if meta, _ := m.GetMeta("rpc.latency"); meta.Kind != KindHistogram {
+		t.Errorf("kind: got=%q", meta.Kind)
+	}
+	if got, want := m.String(), `{"rpc.latency":{"count":4,"sum":3.05,"bounds":[0.1,1],"counts":[1,2,1]}}`; got != want {
+		t.Errorf("json: got=%s, want=%s", got, want)
+	}
+	var b bytes.Buffer
+	if err := m.WritePrometheus(&b, nil); err != nil {
+		t.Fatalf("WritePrometheus failed: %v", err)
+	}
+	want := `# TYPE rpc_latency histogram
+rpc_latency_bucket{le="0.1"} 1
+rpc_latency_bucket{le="1"} 3
+rpc_latency_bucket{le="+Inf"} 4
+rpc_latency_sum 3.05
+rpc_latency_count 4
+`
+	if got := b.String(); got != want {
+		t.Errorf("prometheus: got=%q, want=%q", got, want)
+	}
+}
+
+func TestExtractWithHistogram(t *testing.T) {
+	m := New()
+	m.Set("n", 1)
+	first := m.Snap()
+	m.Observe("latency", 0.2)
+	m.Set("n", 2)
+	second := m.Snap()
+	second.When = first.When.Add(time.Millisecond)
+	got, err := ExtractNumbers([]*Snapshot{first, second}, time.Millisecond, first.When, second.When.Add(time.Millisecond), []string{"n"})
+	if err != nil {
+		t.Fatalf("ExtractNumbers failed: %v", err)
+	}
+	if len(got) != 2 || got[1][1] != 2 {
+		t.Errorf("got=%v", got)
+	}
+}
+
+func TestObserveKeepsActive(t *testing.T) {
+	m := New()
+	m.SetMaxKeys(2, OverflowEvict)
+	m.Observe("latency", 1)
+	m.Set("idle", 1)
+	// Make both appear long unset, then observe the histogram.
+	old := time.Now().Add(-time.Hour)
+	m.backdate("latency", old)
+	m.backdate("idle", old.Add(time.Second))
+	m.Observe("latency", 2)
+	m.Set("new", 1)
+	if m.Get("latency") == nil || m.Get("idle") != nil {
+		t.Errorf("evicted the active histogram: keys=%q", m.Keys())
+	}
+	m.backdate("latency", old)
+	m.backdate("new", old)
+	m.Prune(time.Minute)
+	if got := m.Keys(); len(got) != 1 || got[0] != "latency" {
+		t.Errorf("pruned: got=%q, want [latency]", got)
+	}
+}
+
+func TestHistogramRejected(t *testing.T) {
+	m := New()
+	m.SetMaxKeys(1, OverflowReject)
+	m.Set("a", 1)
+	m.Histogram("h").Observe(1)
+	m.Summary("s").Observe(1)
+	for _, k := range []string{"h", "s"} {
+		if meta, ok := m.GetMeta(k); ok {
+			t.Errorf("%s: rejected metric described: %+v", k, meta)
+		}
+		if _, ok := m.LastUpdated(k); ok {
+			t.Errorf("%s: rejected metric touched", k)
+		}
+	}
+}
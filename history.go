@@ -0,0 +1,107 @@
+package vars
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// History holds an append-only series of snapshots of some metrics.
+// It is safe for concurrent use.
+type History struct {
+	mu    sync.Mutex
+	snaps []*Snapshot
+	// latest holds the fmt.Sprint value of every metric as of the
+	// most recent snapshot.
+	latest map[string]string
+}
+
+// Record appends a full snapshot of m to the history.
+func (h *History) Record(m *Metrics) {
+	s := m.Snap()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.observe(s, false)
+	h.snaps = append(h.snaps, s)
+}
+
+// RecordTrimmed snapshots m and appends it to the history only if at
+// least one metric value changed since the previous snapshot, in
+// which case it returns true. As for Trim, the appended snapshot only
+// holds the metrics that changed, so the history remains minimal
+// without the need for periodic Trim passes over it.
+func (h *History) RecordTrimmed(m *Metrics) bool {
+	s := m.Snap()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.observe(s, true) {
+		return false
+	}
+	h.snaps = append(h.snaps, s)
+	return true
+}
+
+// observe updates h.latest with the values of s, and reports whether
+// any of them changed. If trim is true, the unchanged values are
+// deleted from s. The caller must hold h.mu.
+func (h *History) observe(s *Snapshot, trim bool) bool {
+	if h.latest == nil {
+		h.latest = make(map[string]string)
+	}
+	changed := false
+	for k, v := range s.Values.Detail {
+		text := fmt.Sprint(v)
+		if was, ok := h.latest[k]; ok && was == text {
+			if trim {
+				delete(s.Values.Detail, k)
+			}
+			continue
+		}
+		h.latest[k] = text
+		changed = true
+	}
+	return changed
+}
+
+// Snapshots returns the snapshots of the history, in the order they
+// were recorded.
+func (h *History) Snapshots() []*Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*Snapshot(nil), h.snaps...)
+}
+
+// Len returns the number of snapshots in the history.
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.snaps)
+}
+
+// Record appends a snapshot of m to h every interval until ctx is
+// done, and then returns ctx.Err(). If final is true, a last snapshot
+// is appended as Record returns, so the history ends with the state
+// at shutdown. Since History is safe for concurrent use, several
+// Record calls, for example, run by an errgroup, may share h.
+func (m *Metrics) Record(ctx context.Context, interval time.Duration, h *History, final bool) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	if interval <= 0 {
+		return ErrBadStep
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			h.Record(m)
+		case <-ctx.Done():
+			if final {
+				h.Record(m)
+			}
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package vars
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	m := New()
+	h := &History{}
+	m.Set("a", 1)
+	m.Set("b", "x")
+	if !h.RecordTrimmed(m) {
+		t.Fatal("first snapshot not recorded")
+	}
+	if h.RecordTrimmed(m) {
+		t.Error("unchanged snapshot recorded")
+	}
+	m.Set("a", 2)
+	if !h.RecordTrimmed(m) {
+		t.Error("changed snapshot not recorded")
+	}
+	h.Record(m)
+	snaps := h.Snapshots()
+	if got, want := len(snaps), 3; got != want || h.Len() != want {
+		t.Fatalf("bad number of snapshots: got=%d, want=%d", got, want)
+	}
+	if got := len(snaps[1].Values.Detail); got != 1 || snaps[1].Values.Detail["a"] != 2 {
+		t.Errorf("trimmed snapshot holds %v", snaps[1].Values.Detail)
+	}
+	if got := len(snaps[2].Values.Detail); got != 2 {
+		t.Errorf("full snapshot holds %v", snaps[2].Values.Detail)
+	}
+}
+
+func TestMetricsRecord(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	h := &History{}
+	if err := m.Record(context.Background(), 0, h, false); !errors.Is(err, ErrBadStep) {
+		t.Errorf("zero interval: got err=%v, want=%v", err, ErrBadStep)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- m.Record(ctx, time.Millisecond, h, true)
+	}()
+	for h.Len() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got err=%v, want=%v", err, context.Canceled)
+	}
+	n := h.Len()
+	m.Set("a", 2)
+	time.Sleep(5 * time.Millisecond)
+	if got := h.Len(); got != n {
+		t.Errorf("recorded after return: got=%d, want=%d", got, n)
+	}
+}
@@ -0,0 +1,98 @@
+package vars
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// statusRecorder is an http.ResponseWriter that records the status
+// code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it. Informational
+// codes, other than http.StatusSwitchingProtocols, precede the final
+// status of the response, so they are written but not recorded.
+func (r *statusRecorder) WriteHeader(code int) {
+	informational := code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+	if r.status == 0 && !informational {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes b, recording an implicit http.StatusOK.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// ResponseWriter supports it, recording an implicit http.StatusOK.
+func (r *statusRecorder) Flush() {
+	f, ok := r.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	f.Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, for the benefit of
+// http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// HTTPMiddleware is HTTPMiddlewareWithPrefix with the prefix "http".
+func HTTPMiddleware(m *Metrics, next http.Handler) http.Handler {
+	return HTTPMiddlewareWithPrefix(m, "http", next)
+}
+
+// HTTPMiddlewareWithPrefix returns an http.Handler that serves
+// requests with next, and records metrics of them in m:
+//
+//	prefix.requests.2xx  counters of the requests served, by the
+//	                     class of their status code, 1xx to 5xx
+//	prefix.inflight      a gauge of the requests being served
+//	prefix.latency       a Histogram of the seconds taken to serve
+//	                     requests, with DefaultBuckets
+//
+// A request for which next panics is counted as a 5xx response.
+func HTTPMiddlewareWithPrefix(m *Metrics, prefix string, next http.Handler) http.Handler {
+	var classes [5]*Counter
+	for i := range classes {
+		classes[i] = m.Counter(prefix + ".requests." + strconv.Itoa(i+1) + "xx")
+	}
+	inflight := m.Gauge(prefix + ".inflight")
+	latency := m.Histogram(prefix + ".latency")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inflight.Inc()
+		rec := &statusRecorder{ResponseWriter: w}
+		served := false
+		defer func() {
+			latency.Observe(time.Since(start).Seconds())
+			inflight.Dec()
+			status := rec.status
+			switch {
+			case !served:
+				status = http.StatusInternalServerError
+			case status == 0:
+				status = http.StatusOK
+			}
+			if c := status/100 - 1; c >= 0 && c < len(classes) {
+				classes[c].Inc()
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		served = true
+	})
+}
@@ -0,0 +1,75 @@
+package vars
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	m := New()
+	var inflight float64
+	h := HTTPMiddlewareWithPrefix(m, "api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight, _ = m.GetNumber("api.inflight")
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/hints":
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusNotFound)
+		case "/panic":
+			panic(http.ErrAbortHandler)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	for _, path := range []string{"/", "/", "/missing", "/hints", "/panic"} {
+		func() {
+			defer func() { recover() }()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}()
+	}
+	if inflight != 1 {
+		t.Errorf("inflight while serving: got=%g, want=1", inflight)
+	}
+	vs := map[string]float64{
+		"api.requests.1xx": 0,
+		"api.requests.2xx": 2,
+		"api.requests.4xx": 2,
+		"api.requests.5xx": 1,
+		"api.inflight":     0,
+	}
+	for k, want := range vs {
+		if got, err := m.GetNumber(k); err != nil || got != want {
+			t.Errorf("%s: got=%g, %v, want=%g", k, got, err, want)
+		}
+	}
+	if h, ok := m.Get("api.latency").(*Histogram); !ok || h.Count() != 5 {
+		t.Errorf("latency: got=%v", m.Get("api.latency"))
+	}
+}
+
+// plainWriter is an http.ResponseWriter that is not an http.Flusher.
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+func TestHTTPMiddlewareFlush(t *testing.T) {
+	m := New()
+	h := HTTPMiddleware(m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("http.Flusher hidden by the middleware")
+		}
+		f.Flush()
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.Flushed || w.Code != http.StatusOK {
+		t.Errorf("got flushed=%v code=%d, want flushed=true code=200", w.Flushed, w.Code)
+	}
+	h.ServeHTTP(plainWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/", nil))
+	if got, err := m.GetNumber("http.requests.2xx"); err != nil || got != 2 {
+		t.Errorf("requests: got=%g, %v, want=2", got, err)
+	}
+}
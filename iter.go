@@ -0,0 +1,21 @@
+//go:build go1.23
+
+package vars
+
+import "iter"
+
+// All returns an iterator over the metrics, in key order, for use
+// with range loops:
+//
+//	for k, v := range m.All() {
+//		...
+//	}
+//
+// As for ForEach, the iterated values are those present when the
+// loop starts, and no lock is held while the loop body runs, so the
+// body may itself use the methods of m.
+func (m *Metrics) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		m.ForEach(yield)
+	}
+}
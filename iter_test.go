@@ -0,0 +1,27 @@
+//go:build go1.23
+
+package vars
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	m.Set("c", 3)
+	var seen []string
+	for k, v := range m.All() {
+		m.Set("d", 4)
+		seen = append(seen, fmt.Sprint(k, "=", v))
+		if k == "b" {
+			break
+		}
+	}
+	if got, want := strings.Join(seen, ","), "a=1,b=two"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,243 @@
+package vars
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the version of the JSON encoding used to persist
+// snapshots, see SaveSnapshots. Persisted data records the version it
+// was written with, and decoders reject data with any other version
+// with an error wrapping ErrVersion, rather than misreading it. The
+// version is increased whenever the encoding changes incompatibly.
+const FormatVersion = 1
+
+// checkVersion confirms data of format version v can be decoded.
+func checkVersion(v int) error {
+	if v != FormatVersion {
+		return fmt.Errorf("got version %d, want %d: %w", v, FormatVersion, ErrVersion)
+	}
+	return nil
+}
+
+// SetJSON parses raw as a JSON value and sets metric k to the result.
+// JSON numbers are stored as float64 values, objects as
+// map[string]interface{} values and arrays as []interface{} values.
+func (m *Metrics) SetJSON(k string, raw json.RawMessage) error {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("metric %q: %w", k, err)
+	}
+	return m.Set(k, v)
+}
+
+// String returns the current metric values as a JSON object. Values
+// that cannot be represented in JSON, such as NaN, are rendered as
+// JSON strings holding their fmt.Sprint text.
+func (m *Metrics) String() string {
+	if m == nil {
+		return "null"
+	}
+	m.Collect()
+	return string(encodeValues(m.values()))
+}
+
+// MarshalJSON encodes the current metric values as a JSON object, as
+// for String.
+func (m *Metrics) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	m.Collect()
+	return encodeValues(m.values()), nil
+}
+
+// UnmarshalJSON replaces all of the metric values with those of a JSON
+// object, as for Replace. Values are decoded as described for
+// Snapshot.UnmarshalJSON, so integers are recovered exactly as int64
+// values.
+func (m *Metrics) UnmarshalJSON(data []byte) error {
+	detail, err := decodeValues(data)
+	if err != nil {
+		return err
+	}
+	return m.Replace(detail)
+}
+
+// encodeValues returns the JSON object representing the metric
+// values of detail, as described for String.
+func encodeValues(detail map[string]interface{}) []byte {
+	ks := make([]string, 0, len(detail))
+	for k := range detail {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range ks {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		v := detail[k]
+		value, err := json.Marshal(v)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(v))
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// MarshalJSON encodes the snapshot as a JSON object with two fields:
+// "when", the RFC3339 time of the snapshot, and "values", the metric
+// values as encoded by Metrics.String.
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	when, err := json.Marshal(s.When)
+	if err != nil {
+		return nil, err
+	}
+	values := encodeValues(s.Values.values())
+	b := make([]byte, 0, len(when)+len(values)+20)
+	b = append(b, `{"when":`...)
+	b = append(b, when...)
+	b = append(b, `,"values":`...)
+	b = append(b, values...)
+	return append(b, '}'), nil
+}
+
+// UnmarshalJSON decodes a snapshot encoded by MarshalJSON. Metric
+// values are decoded as for SetJSON, except that JSON numbers holding
+// integers are decoded as int64 values, so integer metrics are
+// recovered exactly. Values of other types, such as time.Duration or
+// time.Time, are recovered as the integers and strings they are
+// encoded as.
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		When   time.Time       `json:"when"`
+		Values json.RawMessage `json:"values"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	detail, err := decodeValues(raw.Values)
+	if err != nil {
+		return err
+	}
+	*s = Snapshot{When: raw.When, Values: &Metrics{Detail: detail}}
+	return nil
+}
+
+// decodeValues decodes a JSON object of metric values, as encoded by
+// encodeValues, decoding integer numbers as int64 values.
+func decodeValues(data []byte) (map[string]interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var detail map[string]interface{}
+	if err := d.Decode(&detail); err != nil {
+		return nil, err
+	}
+	if detail == nil {
+		detail = make(map[string]interface{})
+	}
+	for k, v := range detail {
+		detail[k] = fromJSONNumbers(v)
+	}
+	return detail, nil
+}
+
+// fromJSONNumbers replaces the json.Number values held by v with
+// int64 values, for integers, or float64 values.
+func fromJSONNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, y := range x {
+			x[k] = fromJSONNumbers(y)
+		}
+	case []interface{}:
+		for i, y := range x {
+			x[i] = fromJSONNumbers(y)
+		}
+	}
+	return v
+}
+
+// SnapshotJSON snapshots the current metric values and returns the
+// snapshot encoded as JSON, as described for Snapshot.MarshalJSON.
+func (m *Metrics) SnapshotJSON() ([]byte, error) {
+	if m == nil {
+		return nil, ErrInvalid
+	}
+	return m.Snap().MarshalJSON()
+}
+
+// savedSnapshots is the JSON encoding of a series of snapshots used
+// by SaveSnapshots.
+type savedSnapshots struct {
+	Version   int         `json:"version"`
+	Snapshots []*Snapshot `json:"snapshots"`
+}
+
+// SaveSnapshots writes snaps to w as a JSON object holding the
+// FormatVersion and the snapshots, each encoded as for
+// Snapshot.MarshalJSON. The series can be reloaded with
+// LoadSnapshots. For example, with FormatVersion 1:
+//
+//	{"version":1,"snapshots":[{"when":"2024-01-02T03:04:05Z","values":{"n":1}}]}
+//
+// This encoding only changes along with FormatVersion, so saved
+// series remain loadable, or are rejected, never misread.
+func SaveSnapshots(w io.Writer, snaps []*Snapshot) error {
+	return json.NewEncoder(w).Encode(savedSnapshots{Version: FormatVersion, Snapshots: snaps})
+}
+
+// LoadSnapshots reads a series of snapshots written by SaveSnapshots.
+// The metric values are decoded as for Snapshot.UnmarshalJSON. So the
+// series can be used with Infer and ExtractNumbers, it fails with an
+// error wrapping ErrUnsorted if the snapshots are not in time order.
+func LoadSnapshots(r io.Reader) ([]*Snapshot, error) {
+	var saved savedSnapshots
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(saved.Version); err != nil {
+		return nil, err
+	}
+	for i, s := range saved.Snapshots {
+		if s == nil {
+			return nil, fmt.Errorf("snapshot %d is null: %w", i, ErrInvalid)
+		}
+		if i != 0 && s.When.Before(saved.Snapshots[i-1].When) {
+			return nil, fmt.Errorf("snapshot %d at %v follows %v: %w", i, s.When, saved.Snapshots[i-1].When, ErrUnsorted)
+		}
+	}
+	return saved.Snapshots, nil
+}
+
+// marshalDerived encodes the derived metrics of a value as a JSON
+// object, with non-finite numbers encoded as null.
+func marshalDerived(d map[string]interface{}) ([]byte, error) {
+	obj := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			v = nil
+		}
+		obj[strings.TrimPrefix(k, ".")] = v
+	}
+	return json.Marshal(obj)
+}
@@ -0,0 +1,210 @@
+package vars
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetJSON(t *testing.T) {
+	m := New()
+	if err := m.SetJSON("n", json.RawMessage(`42.5`)); err != nil {
+		t.Fatalf("SetJSON failed: %v", err)
+	}
+	if v, err := m.GetNumber("n"); err != nil || v != 42.5 {
+		t.Errorf("got=%g, %v, want=42.5", v, err)
+	}
+	if err := m.SetJSON("s", json.RawMessage(`"text"`)); err != nil || m.Get("s") != "text" {
+		t.Errorf("string: got=%v, %v", m.Get("s"), err)
+	}
+	if err := m.SetJSON("bad", json.RawMessage(`{`)); err == nil {
+		t.Error("invalid JSON accepted")
+	}
+	if m.Get("bad") != nil {
+		t.Error("invalid JSON stored")
+	}
+}
+
+func TestMetricsString(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	m.Set("c", math.NaN())
+	if got, want := m.String(), `{"a":1,"b":"two","c":"NaN"}`; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(m.String()), &v); err != nil {
+		t.Errorf("invalid JSON: %v", err)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	if err := checkVersion(FormatVersion); err != nil {
+		t.Errorf("current version rejected: %v", err)
+	}
+	for _, v := range []int{0, FormatVersion + 1} {
+		if err := checkVersion(v); !errors.Is(err, ErrVersion) {
+			t.Errorf("version %d: got=%v, want=%v", v, err, ErrVersion)
+		}
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	d, err := m.SnapshotJSON()
+	if err != nil {
+		t.Fatalf("SnapshotJSON failed: %v", err)
+	}
+	var got struct {
+		When   time.Time              `json:"when"`
+		Values map[string]interface{} `json:"values"`
+	}
+	if err := json.Unmarshal(d, &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", d, err)
+	}
+	if time.Since(got.When) > time.Minute || got.Values["a"] != 1.0 || got.Values["b"] != "two" {
+		t.Errorf("bad snapshot JSON: %s", d)
+	}
+	s := m.Snap()
+	enc, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	when, _ := json.Marshal(s.When)
+	if want := `{"when":` + string(when) + `,"values":{"a":1,"b":"two"}}`; string(enc) != want {
+		t.Errorf("got=%s, want=%s", enc, want)
+	}
+}
+
+func TestSnapshotUnmarshalJSON(t *testing.T) {
+	m := New()
+	m.Set("i", 1<<60+1)
+	m.Set("f", 2.5)
+	m.Set("s", "text")
+	d, err := m.SnapshotJSON()
+	if err != nil {
+		t.Fatalf("SnapshotJSON failed: %v", err)
+	}
+	var s Snapshot
+	if err := json.Unmarshal(d, &s); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if got, want := s.Values.String(), m.String(); got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	if v := s.Values.Get("i"); v != int64(1<<60+1) {
+		t.Errorf("integer: got=%v (%T)", v, v)
+	}
+}
+
+func TestSaveLoadSnapshots(t *testing.T) {
+	m := New()
+	var snaps []*Snapshot
+	for i := 0; i < 3; i++ {
+		m.Set("n", i)
+		m.Set("f", 0.5*float64(i))
+		m.Set("s", fmt.Sprint("state", i))
+		s := m.Snap()
+		s.When = time.Unix(100+int64(i), 0)
+		snaps = append(snaps, s)
+	}
+	var b bytes.Buffer
+	if err := SaveSnapshots(&b, snaps); err != nil {
+		t.Fatalf("SaveSnapshots failed: %v", err)
+	}
+	loaded, err := LoadSnapshots(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("LoadSnapshots failed: %v", err)
+	}
+	if len(loaded) != len(snaps) {
+		t.Fatalf("got %d snapshots, want %d", len(loaded), len(snaps))
+	}
+	for i, s := range loaded {
+		if !s.When.Equal(snaps[i].When) {
+			t.Errorf("[%d] got when=%v, want=%v", i, s.When, snaps[i].When)
+		}
+		if got, want := s.Values.String(), snaps[i].Values.String(); got != want {
+			t.Errorf("[%d] got=%s, want=%s", i, got, want)
+		}
+		if _, ok := s.Get("s").(string); !ok {
+			t.Errorf("[%d] string value decoded as %T", i, s.Get("s"))
+		}
+	}
+	if _, v, err := Infer(loaded, loaded[1].When, "n"); err != nil || v != int64(1) {
+		t.Errorf("Infer on loaded snapshots: got=%v, %v", v, err)
+	}
+	snaps[0], snaps[2] = snaps[2], snaps[0]
+	b.Reset()
+	SaveSnapshots(&b, snaps)
+	if _, err := LoadSnapshots(&b); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("got err=%v, want=%v", err, ErrUnsorted)
+	}
+	if _, err := LoadSnapshots(strings.NewReader(`{"version":99,"snapshots":[]}`)); !errors.Is(err, ErrVersion) {
+		t.Errorf("got err=%v, want=%v", err, ErrVersion)
+	}
+}
+
+func TestMetricsJSON(t *testing.T) {
+	m := New()
+	m.Set("i", int64(1<<60+1))
+	m.Set("f", 0.5)
+	m.Set("s", "x")
+	m.Set("nan", math.NaN())
+	d, err := json.Marshal(struct {
+		M *Metrics `json:"m"`
+	}{m})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if got, want := string(d), `{"m":{"f":0.5,"i":1152921504606846977,"nan":"NaN","s":"x"}}`; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	var r struct {
+		M *Metrics `json:"m"`
+	}
+	if err := json.Unmarshal(d, &r); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if got := r.M.Get("i"); got != int64(1<<60+1) {
+		t.Errorf("integer: got=%v (%T)", got, got)
+	}
+	if got := r.M.Get("f"); got != 0.5 {
+		t.Errorf("float: got=%v (%T)", got, got)
+	}
+	r.M.Inc("i")
+	if got := r.M.Get("i"); got != int64(1<<60+2) {
+		t.Errorf("decoded metrics not usable: got=%v", got)
+	}
+}
+
+func TestLoadSnapshotsVersion1(t *testing.T) {
+	const saved = `{"version":1,"snapshots":[{"when":"2024-01-02T03:04:05Z","values":{"n":1,"s":"x"}},{"when":"2024-01-02T03:04:06Z","values":{"n":2.5}}]}`
+	snaps, err := LoadSnapshots(strings.NewReader(saved))
+	if err != nil {
+		t.Fatalf("LoadSnapshots failed: %v", err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("got %d snapshots, want 2", len(snaps))
+	}
+	if got, want := snaps[1].When, time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got when=%v, want=%v", got, want)
+	}
+	if got := snaps[0].Get("n"); got != int64(1) {
+		t.Errorf("got=%v (%T), want=1 (int64)", got, got)
+	}
+	var b bytes.Buffer
+	if err := SaveSnapshots(&b, snaps); err != nil {
+		t.Fatalf("SaveSnapshots failed: %v", err)
+	}
+	if got := strings.TrimSpace(b.String()); got != saved {
+		t.Errorf("format changed: got=%s, want=%s", got, saved)
+	}
+}
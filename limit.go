@@ -0,0 +1,186 @@
+package vars
+
+import (
+	"container/list"
+	"time"
+)
+
+// Overflow selects how a Metrics enforces the limit on its number of
+// distinct metrics, set with SetMaxKeys.
+type Overflow int
+
+const (
+	// OverflowReject refuses to create new metrics once the limit
+	// has been reached. Set fails with ErrTooManyKeys.
+	OverflowReject Overflow = iota
+	// OverflowEvict deletes the least recently updated metric to
+	// make room for a new one. Metrics with no known LastUpdated
+	// time are evicted first.
+	OverflowEvict
+)
+
+// SetMaxKeys limits the number of distinct metrics of m to n, which
+// protects a process from unbounded growth when metric names are
+// derived from untrusted input. When the limit is reached, new
+// metrics are handled according to policy. Metrics that already exist
+// can always be updated. A limit of n <= 0, the default, means no
+// limit. Lowering the limit does not remove existing metrics.
+func (m *Metrics) SetMaxKeys(n int, policy Overflow) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	m.maxKeys, m.overflow = n, policy
+	return nil
+}
+
+// admit ensures there is room to set metric k. The caller must hold
+// m.mu.
+func (m *Metrics) admit(k string) error {
+	if m.maxKeys <= 0 || len(m.Detail) < m.maxKeys {
+		return nil
+	}
+	if _, ok := m.Detail[k]; ok {
+		return nil
+	}
+	if m.overflow != OverflowEvict {
+		return ErrTooManyKeys
+	}
+	for len(m.Detail) >= m.maxKeys {
+		oldest, ok := m.leastRecent()
+		if !ok {
+			break
+		}
+		m.drop(oldest)
+	}
+	return nil
+}
+
+// touch records when a metric was set. The touches are kept in a list
+// ordered from the least to the most recently set metric, so the
+// least recently updated one is found without scanning all of them.
+type touch struct {
+	k string
+	t time.Time
+}
+
+// touch records that metric k was set at time t. The caller must hold
+// m.mu.
+func (m *Metrics) touch(k string, t time.Time) {
+	if m.touched == nil {
+		m.touched = make(map[string]*list.Element)
+		m.recency = list.New()
+	}
+	if e, ok := m.touched[k]; ok {
+		e.Value.(*touch).t = t
+		m.reorder(e)
+		return
+	}
+	m.touched[k] = m.recency.PushBack(&touch{k: k, t: t})
+	m.reorder(m.touched[k])
+}
+
+// reorder moves e, whose time may have changed, to its place in the
+// recency list. Since times only advance in normal use, that is
+// usually at the back. The caller must hold m.mu.
+func (m *Metrics) reorder(e *list.Element) {
+	t := e.Value.(*touch).t
+	at := m.recency.Back()
+	for at != nil && (at == e || at.Value.(*touch).t.After(t)) {
+		at = at.Prev()
+	}
+	if at == nil {
+		m.recency.MoveToFront(e)
+	} else {
+		m.recency.MoveAfter(e, at)
+	}
+}
+
+// touchedAt returns the time metric k was last set via the methods of
+// m, and whether that is known. The caller must hold m.mu.
+func (m *Metrics) touchedAt(k string) (time.Time, bool) {
+	if e, ok := m.touched[k]; ok {
+		return e.Value.(*touch).t, true
+	}
+	return time.Time{}, false
+}
+
+// leastRecent returns the least recently updated metric, preferring
+// one with no known LastUpdated time. A metric updated in place since
+// it was set is moved to its place in the recency list on the way.
+// The caller must hold m.mu.
+func (m *Metrics) leastRecent() (string, bool) {
+	if len(m.touched) < len(m.Detail) {
+		// Some metrics were added directly to Detail.
+		for k := range m.Detail {
+			if _, ok := m.touched[k]; !ok {
+				return k, true
+			}
+		}
+	}
+	for e := m.recency.Front(); e != nil; e = m.recency.Front() {
+		tc := e.Value.(*touch)
+		if _, ok := m.Detail[tc.k]; !ok {
+			// Deleted directly from Detail.
+			m.drop(tc.k)
+			continue
+		}
+		if t, _ := m.updated(tc.k); t.After(tc.t) {
+			tc.t = t
+			m.reorder(e)
+			continue
+		}
+		return tc.k, true
+	}
+	return "", false
+}
+
+// drop deletes metric k, along with everything m records about it,
+// on its eviction or pruning. The caller must hold m.mu.
+func (m *Metrics) drop(k string) {
+	release(m.Detail[k])
+	delete(m.Detail, k)
+	if e, ok := m.touched[k]; ok {
+		m.recency.Remove(e)
+		delete(m.touched, k)
+	}
+	delete(m.formats, k)
+	delete(m.meta, k)
+	delete(m.collected, k)
+}
+
+// growthHook is a callback registered with OnGrowthPast.
+type growthHook struct {
+	n  int
+	fn func(current int)
+}
+
+// OnGrowthPast registers fn to be called each time the number of
+// distinct metrics of m grows past n, that is, from n or fewer to
+// more than n. It is called with the number of metrics at that time.
+// This lets a process react to a cardinality explosion, for example,
+// by logging it and shedding metrics, without polling. As for all
+// callbacks, fn is called without holding any lock of m.
+func (m *Metrics) OnGrowthPast(n int, fn func(current int)) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	m.growth = append(m.growth, growthHook{n: n, fn: fn})
+	return nil
+}
+
+// grew queues the calls of the OnGrowthPast callbacks for a change in
+// the number of metrics from before to the current number. The caller
+// must hold m.mu.
+func (m *Metrics) grew(before int) {
+	current := len(m.Detail)
+	for _, h := range m.growth {
+		if before <= h.n && current > h.n {
+			fn := h.fn
+			m.pending = append(m.pending, func() { fn(current) })
+		}
+	}
+}
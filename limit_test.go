@@ -0,0 +1,87 @@
+package vars
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxKeys(t *testing.T) {
+	m := New()
+	m.SetMaxKeys(2, OverflowReject)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	if err := m.Set("c", 3); err != ErrTooManyKeys {
+		t.Errorf("got err=%v, want=%v", err, ErrTooManyKeys)
+	}
+	m.Add("d", 1)
+	if err := m.Set("a", 4); err != nil {
+		t.Errorf("updating existing metric failed: %v", err)
+	}
+	if got, want := strings.Join(m.Keys(), ","), "a,b"; got != want {
+		t.Errorf("rejecting: got=%q, want=%q", got, want)
+	}
+
+	m.SetMaxKeys(2, OverflowEvict)
+	m.backdate("a", time.Now().Add(-time.Minute))
+	if err := m.Set("c", 3); err != nil {
+		t.Errorf("evicting Set failed: %v", err)
+	}
+	if got, want := strings.Join(m.Keys(), ","), "b,c"; got != want {
+		t.Errorf("evicting: got=%q, want=%q", got, want)
+	}
+}
+
+func TestOnGrowthPast(t *testing.T) {
+	m := New()
+	var calls []int
+	m.OnGrowthPast(2, func(current int) {
+		calls = append(calls, current)
+		m.Set("from.callback", current)
+	})
+	m.Set("a", 1)
+	m.Set("b", 1)
+	if len(calls) != 0 {
+		t.Fatalf("early call: %v", calls)
+	}
+	m.Set("c", 1)
+	m.Set("c", 2)
+	if got, want := fmt.Sprint(calls), "[3]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	m.Replace(map[string]interface{}{"x": 1})
+	m.Replace(map[string]interface{}{"x": 1, "y": 2, "z": 3})
+	if got, want := fmt.Sprint(calls), "[3 3]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
+
+func TestEvictionForgetsMetrics(t *testing.T) {
+	m := New()
+	m.SetMaxKeys(1000, OverflowEvict)
+	for i := 0; i < 100000; i++ {
+		k := fmt.Sprint("k", i)
+		m.Set(k, i)
+		m.SetMeta(k, Meta{Kind: KindGauge})
+		m.SetFormatter(k, FormatBytes)
+	}
+	if len(m.Detail) != 1000 || len(m.meta) != 1000 || len(m.formats) != 1000 || len(m.touched) != 1000 {
+		t.Errorf("retained %d values, %d meta, %d formats, %d times", len(m.Detail), len(m.meta), len(m.formats), len(m.touched))
+	}
+	if m.Get("k99000") == nil || m.Get("k98999") != nil {
+		t.Error("did not evict the least recently set metrics")
+	}
+	m.backdate("k99999", time.Now().Add(-time.Hour))
+	m.Prune(time.Minute)
+	if _, ok := m.GetMeta("k99999"); ok || len(m.Detail) != 999 {
+		t.Errorf("pruning left %d metrics, meta=%v", len(m.Detail), ok)
+	}
+}
+
+// backdate pretends metric k was last set at time t.
+func (m *Metrics) backdate(k string, t time.Time) {
+	m.mu.Lock()
+	defer m.unlock()
+	m.touch(k, t)
+}
@@ -0,0 +1,204 @@
+package vars
+
+import (
+	"fmt"
+	"math"
+)
+
+// Kind identifies the type of a metric, for the benefit of exporters
+// that distinguish between them.
+type Kind string
+
+// The kinds of metrics known to this package.
+const (
+	KindUntyped   Kind = "untyped"
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+	KindSummary   Kind = "summary"
+)
+
+// Meta holds descriptive information about a metric. It is carried
+// into snapshots of the metrics, but it is not a metric value.
+type Meta struct {
+	// Kind is the type of the metric. An empty Kind is
+	// equivalent to KindUntyped.
+	Kind Kind
+	// Help is a one line description of the metric.
+	Help string
+	// Unit names the unit of the metric value, for example
+	// "bytes".
+	Unit string
+}
+
+// UnitBytes is the Meta Unit of metrics holding a number of bytes.
+// The text outputs of this package render such metrics with
+// FormatBytes, unless they have their own formatter.
+const UnitBytes = "bytes"
+
+// UnitPercent is the Meta Unit of metrics holding a percentage. The
+// text outputs of this package render such metrics with a trailing
+// "%", unless they have their own formatter.
+const UnitPercent = "percent"
+
+// SetBytes sets metric k to the byte quantity n, and sets the Unit of
+// its descriptive information to UnitBytes. The value is stored as an
+// int64, so it is extracted exactly by AsInt64.
+func (m *Metrics) SetBytes(k string, n int64) error {
+	return m.setWithUnit(k, n, UnitBytes)
+}
+
+// SetPercent sets metric k to the percentage v, and sets the Unit of
+// its descriptive information to UnitPercent. It fails with
+// ErrOutOfRange, leaving the metric unchanged, if v is not in the
+// range [0, 100], which catches the common mistake of storing a ratio
+// in the range [0, 1] instead.
+func (m *Metrics) SetPercent(k string, v float64) error {
+	if !(v >= 0 && v <= 100) {
+		return fmt.Errorf("percentage %g: %w", v, ErrOutOfRange)
+	}
+	return m.setWithUnit(k, v, UnitPercent)
+}
+
+// setWithUnit sets metric k to v and the Unit of its descriptive
+// information to unit.
+func (m *Metrics) setWithUnit(k string, v interface{}, unit string) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if err := m.set(k, v); err != nil {
+		return err
+	}
+	if m.meta == nil {
+		m.meta = make(map[string]Meta)
+	}
+	meta := m.meta[k]
+	meta.Unit = unit
+	m.meta[k] = meta
+	return nil
+}
+
+// FormatBytes renders a numerical value, v, as a number of bytes
+// using binary units, for example, "1.5 GiB". A nil value is rendered
+// as "", and other non-numerical values with fmt.Sprint. It can be
+// used with SetFormatter.
+func FormatBytes(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	n, err := AsNumber(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if math.Abs(n) < 1024 {
+		return fmt.Sprintf("%g B", n)
+	}
+	i := 0
+	for ; math.Abs(n) >= 1024 && i < len(byteUnits); i++ {
+		n /= 1024
+	}
+	return fmt.Sprintf("%.1f %s", n, byteUnits[i-1])
+}
+
+// byteUnits are the binary units used by FormatBytes.
+var byteUnits = []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// formatPercent renders a percentage, v, with a trailing "%". A nil
+// value is rendered as "".
+func formatPercent(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v, "%")
+}
+
+// unitFormatter returns the display formatter implied by a Meta Unit,
+// or nil if there is none.
+func unitFormatter(unit string) func(interface{}) string {
+	switch unit {
+	case UnitBytes:
+		return FormatBytes
+	case UnitPercent:
+		return formatPercent
+	}
+	return nil
+}
+
+// SetMeta sets the descriptive information of metric k.
+func (m *Metrics) SetMeta(k string, meta Meta) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if m.meta == nil {
+		m.meta = make(map[string]Meta)
+	}
+	m.meta[k] = meta
+	return nil
+}
+
+// GetMeta returns the descriptive information of metric k, and
+// whether any has been set.
+func (m *Metrics) GetMeta(k string) (Meta, bool) {
+	if m == nil {
+		return Meta{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	meta, ok := m.meta[k]
+	return meta, ok
+}
+
+// Registry wraps a *Metrics and tracks the registration of its
+// metrics: their names, kinds and help text. Exporters use this
+// information to describe each metric exactly once, however many
+// snapshots of the metrics they render.
+type Registry struct {
+	m *Metrics
+}
+
+// NewRegistry returns a Registry for the metrics m.
+func NewRegistry(m *Metrics) *Registry {
+	return &Registry{m: m}
+}
+
+// Metrics returns the metrics of the registry.
+func (r *Registry) Metrics() *Metrics {
+	return r.m
+}
+
+// Register registers a metric, name, of the given kind, described by
+// help. Registering the same name again with the same kind updates
+// the help text. Registering it with a different kind fails with an
+// error wrapping ErrConflict.
+func (r *Registry) Register(name string, kind Kind, help string) error {
+	m := r.m
+	if m == nil {
+		return ErrInvalid
+	}
+	if kind == "" {
+		kind = KindUntyped
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	meta, ok := m.meta[name]
+	if ok && meta.Kind != "" && meta.Kind != kind {
+		return fmt.Errorf("%q is a %s, not a %s: %w", name, meta.Kind, kind, ErrConflict)
+	}
+	meta.Kind, meta.Help = kind, help
+	if m.meta == nil {
+		m.meta = make(map[string]Meta)
+	}
+	m.meta[name] = meta
+	return nil
+}
+
+// Registered returns the registered information of metric name, and
+// whether it has been registered.
+func (r *Registry) Registered(name string) (Meta, bool) {
+	meta, ok := r.m.GetMeta(name)
+	return meta, ok && meta.Kind != ""
+}
@@ -0,0 +1,98 @@
+package vars
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	m := New()
+	if _, ok := m.GetMeta("x"); ok {
+		t.Error("unexpected meta for \"x\"")
+	}
+	want := Meta{Kind: KindGauge, Help: "memory in use", Unit: "bytes"}
+	if err := m.SetMeta("x", want); err != nil {
+		t.Fatalf("SetMeta failed: %v", err)
+	}
+	if got, ok := m.GetMeta("x"); !ok || got != want {
+		t.Errorf("got=%v, %v, want=%v", got, ok, want)
+	}
+	if got, ok := m.Snap().Values.GetMeta("x"); !ok || got != want {
+		t.Errorf("snapshot got=%v, %v, want=%v", got, ok, want)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(New())
+	if err := r.Register("requests", KindCounter, "requests served"); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := r.Register("requests", KindCounter, "total requests served"); err != nil {
+		t.Errorf("re-registration failed: %v", err)
+	}
+	if err := r.Register("requests", KindGauge, "requests"); !errors.Is(err, ErrConflict) {
+		t.Errorf("conflicting registration: got=%v, want=%v", err, ErrConflict)
+	}
+	got, ok := r.Registered("requests")
+	if want := (Meta{Kind: KindCounter, Help: "total requests served"}); !ok || got != want {
+		t.Errorf("got=%v, %v, want=%v", got, ok, want)
+	}
+	if _, ok := r.Registered("other"); ok {
+		t.Error("unregistered metric reported as registered")
+	}
+}
+
+func TestSetBytes(t *testing.T) {
+	m := New()
+	if err := m.SetBytes("heap", 3<<29); err != nil {
+		t.Fatalf("SetBytes failed: %v", err)
+	}
+	m.SetBytes("big", 1<<53+1)
+	m.SetBytes("small", 512)
+	if meta, _ := m.GetMeta("heap"); meta.Unit != UnitBytes {
+		t.Errorf("got unit=%q, want=%q", meta.Unit, UnitBytes)
+	}
+	if n, ok := AsInt64(m.Get("big")); !ok || n != 1<<53+1 {
+		t.Errorf("inexact value: got=%d, %v", n, ok)
+	}
+	want := "big | 8.0 PiB,heap | 1.5 GiB,small | 512 B"
+	for i, r := range []Reader{m, m.Freeze()} {
+		d, err := MDTable(r, time.Now(), nil)
+		if err != nil {
+			t.Fatalf("[%d] MDTable failed: %v", i, err)
+		}
+		if got := strings.Join(strings.Split(string(d), "\n")[2:5], ","); got != want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, want)
+		}
+	}
+	if got := strings.Join(strings.Split(string(m.DumpMDTable()), "\n")[2:5], ","); got != want {
+		t.Errorf("DumpMDTable: got=%q, want=%q", got, want)
+	}
+}
+
+func TestSetPercent(t *testing.T) {
+	m := New()
+	if err := m.SetPercent("cpu", 80); err != nil {
+		t.Fatalf("SetPercent failed: %v", err)
+	}
+	for _, v := range []float64{-1, 100.5, math.NaN()} {
+		if err := m.SetPercent("cpu", v); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("%g: got err=%v, want=%v", v, err, ErrOutOfRange)
+		}
+	}
+	if got := m.Get("cpu"); got != 80.0 {
+		t.Errorf("got=%v, want=80", got)
+	}
+	if meta, _ := m.GetMeta("cpu"); meta.Unit != UnitPercent {
+		t.Errorf("got unit=%q, want=%q", meta.Unit, UnitPercent)
+	}
+	if got, want := strings.Split(string(m.DumpMDTable()), "\n")[2], "cpu | 80%"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+	if got := formatPercent(nil) + FormatBytes(nil); got != "" {
+		t.Errorf("nil: got=%q, want empty", got)
+	}
+}
@@ -0,0 +1,150 @@
+package vars
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// meterTick is the interval at which the moving averages of a Meter
+// are updated.
+const meterTick = 5 * time.Second
+
+// meterWindows are the periods, in minutes, of the moving averages of
+// a Meter, and meterSuffixes name their derived metrics.
+var (
+	meterWindows  = [3]float64{1, 5, 15}
+	meterSuffixes = [3]string{".rate1m", ".rate5m", ".rate15m"}
+)
+
+// Meter tracks the rate of events as exponentially weighted moving
+// averages over 1, 5 and 15 minutes, in the manner of the Unix load
+// average. The rates are current whenever they are read, without the
+// need for a background goroutine. It is safe for concurrent use.
+//
+// When a Metrics holding a Meter is snapshotted, the Meter is replaced
+// by derived numerical metrics: for a Meter held by metric k, k.count
+// is the number of events, and k.rate1m, k.rate5m and k.rate15m are
+// the moving average rates, per second.
+type Meter struct {
+	mu    sync.Mutex
+	count uint64
+	// pending counts the events since the last tick.
+	pending uint64
+	rates   [3]float64
+	started bool
+	last    time.Time
+	// marked is the time of the most recent Mark.
+	marked time.Time
+
+	// now is the clock used to time the ticks.
+	now func() time.Time
+}
+
+// NewMeter returns a Meter with no recorded events.
+func NewMeter() *Meter {
+	return &Meter{now: time.Now}
+}
+
+// Mark records n events.
+func (e *Meter) Mark(n uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tick()
+	e.count += n
+	e.pending += n
+	e.marked = e.now()
+}
+
+// lastActive returns the time of the most recent Mark.
+func (e *Meter) lastActive() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.marked
+}
+
+// tick folds the events of all of the elapsed ticks into the moving
+// averages. The caller must hold e.mu.
+func (e *Meter) tick() {
+	now := e.now()
+	if !e.started {
+		e.started, e.last = true, now
+		return
+	}
+	ticks := int64(now.Sub(e.last) / meterTick)
+	if ticks <= 0 {
+		return
+	}
+	e.last = e.last.Add(time.Duration(ticks) * meterTick)
+	instant := float64(e.pending) / meterTick.Seconds()
+	e.pending = 0
+	for i, minutes := range meterWindows {
+		keep := math.Exp(-meterTick.Minutes() / minutes)
+		// The first tick carries the pending events, and any
+		// further ones decay the average toward zero.
+		e.rates[i] = instant + keep*(e.rates[i]-instant)
+		e.rates[i] *= math.Pow(keep, float64(ticks-1))
+	}
+}
+
+// Count returns the number of recorded events.
+func (e *Meter) Count() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.count
+}
+
+// Rates returns the 1, 5 and 15 minute moving average rates of
+// events, per second.
+func (e *Meter) Rates() (m1, m5, m15 float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tick()
+	return e.rates[0], e.rates[1], e.rates[2]
+}
+
+// derived returns the derived metrics of the meter, keyed by their
+// suffix.
+func (e *Meter) derived() map[string]interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tick()
+	d := map[string]interface{}{".count": e.count}
+	for i, suffix := range meterSuffixes {
+		d[suffix] = e.rates[i]
+	}
+	return d
+}
+
+// String summarizes the meter for text outputs.
+func (e *Meter) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tick()
+	return fmt.Sprintf("count=%d rate1m=%g rate5m=%g rate15m=%g", e.count, e.rates[0], e.rates[1], e.rates[2])
+}
+
+// MarshalJSON encodes the meter as a JSON object holding its count
+// and rates, named as for the derived metrics.
+func (e *Meter) MarshalJSON() ([]byte, error) {
+	return marshalDerived(e.derived())
+}
+
+// Meter returns the meter held by metric k of m, creating it if k
+// does not hold a *Meter. If creating k would exceed the limit set
+// with SetMaxKeys, and new metrics are rejected, the returned Meter is
+// not held by m.
+func (m *Metrics) Meter(k string) *Meter {
+	if m == nil {
+		return NewMeter()
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	e, ok := m.Detail[k].(*Meter)
+	if !ok {
+		e = NewMeter()
+		m.set(k, e)
+	}
+	return e
+}
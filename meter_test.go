@@ -0,0 +1,48 @@
+package vars
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	m := New()
+	e := m.Meter("events")
+	if m.Meter("events") != e {
+		t.Error("second Meter call returned a new meter")
+	}
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	e.Mark(0)
+	// A steady 10 events per second for an hour.
+	for i := 0; i < 720; i++ {
+		e.Mark(50)
+		now = now.Add(meterTick)
+	}
+	rates := func() []float64 {
+		m1, m5, m15 := e.Rates()
+		return []float64{m1, m5, m15}
+	}
+	steady := rates()
+	for i, minutes := range meterWindows {
+		if want := 10 * (1 - math.Exp(-60/minutes)); math.Abs(steady[i]-want) > 1e-9 {
+			t.Errorf("[%d] steady rate: got=%g, want=%g", i, steady[i], want)
+		}
+	}
+	// Idle for 5 minutes, the averages decay toward zero.
+	now = now.Add(5 * time.Minute)
+	idle := rates()
+	for i, minutes := range meterWindows {
+		if want := steady[i] * math.Exp(-5/minutes); math.Abs(idle[i]-want) > 1e-9 {
+			t.Errorf("[%d] idle rate: got=%g, want=%g", i, idle[i], want)
+		}
+	}
+	s := m.Snap()
+	if got := s.Values.Get("events.count"); got != uint64(36000) {
+		t.Errorf("snapshot count: got=%v, want=36000", got)
+	}
+	if got, err := s.Values.GetNumber("events.rate1m"); err != nil || got != idle[0] {
+		t.Errorf("snapshot rate1m: got=%g, %v, want=%g", got, err, idle[0])
+	}
+}
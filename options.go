@@ -0,0 +1,161 @@
+package vars
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// NonFinite selects how an output treats numeric values that are NaN
+// or ±Inf. Such values are legal float64 values, but they are
+// typically rejected, or silently misread, by the programs that
+// consume the text outputs of this package.
+type NonFinite int
+
+const (
+	// NonFiniteAsIs renders non-finite values literally ("NaN",
+	// "+Inf", "-Inf"). This is the default policy.
+	NonFiniteAsIs NonFinite = iota
+	// NonFiniteSkip omits non-finite values. For tabular outputs,
+	// where a value cannot be omitted on its own, the whole row
+	// holding the value is omitted.
+	NonFiniteSkip
+	// NonFiniteZero replaces non-finite values with 0.
+	NonFiniteZero
+	// NonFiniteError causes the output to fail with an error
+	// wrapping ErrNonFinite.
+	NonFiniteError
+)
+
+// CounterMode selects whether an output leaves the counters it emits
+// untouched, or zeroes them.
+type CounterMode int
+
+const (
+	// Cumulative outputs report the running total of every
+	// numerical metric. This is the default mode.
+	Cumulative CounterMode = iota
+	// ResetOnRead outputs atomically read and zero every counter
+	// they emit, so each output reports only the change since the
+	// previous one. This is the mode expected by delta based
+	// collectors, such as StatsD. It must not be used when feeding
+	// a backend that expects cumulative counters: the backend will
+	// see each reset as a counter restart. The counters are the
+	// numerical metrics with a Kind, see SetMeta, of KindCounter,
+	// KindUntyped or none. Gauges, the metrics set by the
+	// collectors of this package, which set them afresh before
+	// each output, and non-numerical metrics are emitted but
+	// never reset.
+	ResetOnRead
+)
+
+// WriteOptions holds the options that adjust how metric values are
+// rendered by the outputs of this package. A nil *WriteOptions is
+// equivalent to a zero WriteOptions value.
+type WriteOptions struct {
+	// NonFinite is the policy for NaN and ±Inf numeric values.
+	NonFinite NonFinite
+	// Counters selects whether emitted numerical metrics are
+	// reset. Since the modes are exclusive, a single output
+	// cannot both report and preserve cumulative values.
+	Counters CounterMode
+	// Precision, when positive, is the number of significant
+	// digits used to render floating point values in text
+	// outputs. Otherwise they are rendered with the shortest
+	// representation that reads back as the same value.
+	Precision int
+	// Transpose, for the outputs that tabulate metrics over time,
+	// such as WriteCSV, selects a layout of one row per metric
+	// and one column per time, rather than one row per time and
+	// one column per metric.
+	Transpose bool
+	// Timestamps, for the outputs that support it, such as
+	// Snapshot.WritePrometheus, adds the time of the snapshot to
+	// each sample.
+	Timestamps bool
+}
+
+// text renders the metric value v according to opts. Times are
+// always rendered in RFC3339 format, and nil values as empty text.
+func (opts *WriteOptions) text(v interface{}) string {
+	return string(opts.appendText(nil, v))
+}
+
+// appendText appends the text of the metric value v, as rendered by
+// text, to dst, and returns the extended slice. It avoids allocating
+// for the common types of values.
+func (opts *WriteOptions) appendText(dst []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return dst
+	case string:
+		return append(dst, x...)
+	case int:
+		return strconv.AppendInt(dst, int64(x), 10)
+	case int64:
+		return strconv.AppendInt(dst, x, 10)
+	case float64:
+		if opts != nil && opts.Precision > 0 {
+			return strconv.AppendFloat(dst, x, 'g', opts.Precision, 64)
+		}
+		return strconv.AppendFloat(dst, x, 'g', -1, 64)
+	case float32:
+		if opts != nil && opts.Precision > 0 {
+			return strconv.AppendFloat(dst, float64(x), 'g', opts.Precision, 32)
+		}
+		return strconv.AppendFloat(dst, float64(x), 'g', -1, 32)
+	case time.Time:
+		return x.AppendFormat(dst, time.RFC3339Nano)
+	}
+	return fmt.Append(dst, v)
+}
+
+// transpose indicates an output should tabulate metrics as rows.
+func (opts *WriteOptions) transpose() bool {
+	return opts != nil && opts.Transpose
+}
+
+// resetOnRead indicates an output should zero the counters it emits.
+func (opts *WriteOptions) resetOnRead() bool {
+	return opts != nil && opts.Counters == ResetOnRead
+}
+
+// finite applies the NonFinite policy of opts to the numerical value
+// f. It returns the value to output and whether it should be output
+// at all.
+func (opts *WriteOptions) finite(f float64) (float64, bool, error) {
+	if !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f, true, nil
+	}
+	if opts == nil {
+		return f, true, nil
+	}
+	switch opts.NonFinite {
+	case NonFiniteSkip:
+		return f, false, nil
+	case NonFiniteZero:
+		return 0, true, nil
+	case NonFiniteError:
+		return f, false, ErrNonFinite
+	default:
+		return f, true, nil
+	}
+}
+
+// finiteValue applies the NonFinite policy of opts to an arbitrary
+// metric value. Only floating point values can be non-finite, all
+// other values are returned unchanged.
+func (opts *WriteOptions) finiteValue(v interface{}) (interface{}, bool, error) {
+	switch x := v.(type) {
+	case float64:
+		return opts.finite(x)
+	case float32:
+		f, ok, err := opts.finite(float64(x))
+		if f == 0 && x != 0 {
+			return float32(0), ok, err
+		}
+		return v, ok, err
+	}
+	return v, true, nil
+}
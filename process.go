@@ -0,0 +1,31 @@
+package vars
+
+import (
+	"time"
+)
+
+// processStart approximates the time the process started.
+var processStart = time.Now()
+
+// ProcessCollector is a Collector of statistics of the current
+// process. It sets these metrics:
+//
+//	process.uptime    the time since the process started
+//	process.cpu       the user and system CPU time used
+//	process.rss       the bytes of resident memory
+//	process.open_fds  the number of open file descriptors
+//
+// The uptime is measured from the initialization of this package.
+// The cumulative process.cpu has a Kind of KindCounter, and the others
+// KindGauge.
+// The other metrics are only available on Linux, where they are read
+// from /proc, and they are omitted elsewhere.
+type ProcessCollector struct{}
+
+// Collect sets the process metrics in m.
+func (ProcessCollector) Collect(m *Metrics) error {
+	if err := m.setCollected("process.uptime", time.Since(processStart), Meta{Kind: KindGauge}); err != nil {
+		return err
+	}
+	return collectProcess(m)
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package vars
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// collectProcess sets the process metrics read from the kernel.
+func collectProcess(m *Metrics) error {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return fmt.Errorf("process cpu: %w", err)
+	}
+	cpu := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	if err := m.setCollected("process.cpu", cpu, Meta{Kind: KindCounter}); err != nil {
+		return err
+	}
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return fmt.Errorf("process rss: %w", err)
+	}
+	fields := bytes.Fields(statm)
+	if len(fields) < 2 {
+		return fmt.Errorf("process rss: malformed statm %q", statm)
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("process rss: %w", err)
+	}
+	if err := m.setCollected("process.rss", pages*int64(os.Getpagesize()), Meta{Kind: KindGauge, Unit: UnitBytes}); err != nil {
+		return err
+	}
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return fmt.Errorf("process open_fds: %w", err)
+	}
+	// The listing includes the descriptor used to read it.
+	return m.setCollected("process.open_fds", len(fds)-1, Meta{Kind: KindGauge})
+}
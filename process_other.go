@@ -0,0 +1,9 @@
+//go:build !linux
+
+package vars
+
+// collectProcess does nothing, the process metrics read from /proc on
+// Linux are not available.
+func collectProcess(*Metrics) error {
+	return nil
+}
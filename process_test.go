@@ -0,0 +1,37 @@
+package vars
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestProcessCollector(t *testing.T) {
+	m := New()
+	if err := (ProcessCollector{}).Collect(m); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if d, ok := m.Get("process.uptime").(time.Duration); !ok || d <= 0 {
+		t.Errorf("uptime: got=%#v", m.Get("process.uptime"))
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if _, ok := m.Get("process.cpu").(time.Duration); !ok {
+		t.Errorf("cpu: got=%#v", m.Get("process.cpu"))
+	}
+	if n, ok := AsInt64(m.Get("process.rss")); !ok || n <= 0 {
+		t.Errorf("rss: got=%#v", m.Get("process.rss"))
+	}
+	before, _ := m.GetNumber("process.open_fds")
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	(ProcessCollector{}).Collect(m)
+	if after, _ := m.GetNumber("process.open_fds"); after != before+1 {
+		t.Errorf("open_fds: got=%g, want=%g", after, before+1)
+	}
+}
@@ -0,0 +1,214 @@
+package vars
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prometheus timestamps are int64 numbers of milliseconds since the
+// epoch, so only times in this range can be written.
+var (
+	promMinTime = time.UnixMilli(math.MinInt64)
+	promMaxTime = time.UnixMilli(math.MaxInt64)
+)
+
+// promName returns k with every character that is not valid in a
+// Prometheus metric name replaced by an underscore.
+func promName(k string) string {
+	b := []byte(k)
+	for i, c := range b {
+		switch {
+		case c == '_' || c == ':' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i != 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// promValue renders the numerical metric value v as a Prometheus
+// sample value. Integers are rendered exactly.
+func promValue(v interface{}, f float64) string {
+	switch u := v.(type) {
+	case uint:
+		return strconv.FormatUint(uint64(u), 10)
+	case uint64:
+		return strconv.FormatUint(u, 10)
+	}
+	if i, ok := AsInt64(v); ok {
+		return strconv.FormatInt(i, 10)
+	}
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// promHelp escapes the help text of a metric for a Prometheus HELP
+// line.
+var promHelp = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// WritePrometheus writes the numerical metrics of m to w in the
+// Prometheus text exposition format, as for Snapshot.WritePrometheus.
+// If opts selects ResetOnRead, the written counters are zeroed.
+func (m *Metrics) WritePrometheus(w io.Writer, opts *WriteOptions) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.Collect()
+	return m.snap(opts.resetOnRead()).WritePrometheus(w, opts)
+}
+
+// WritePrometheus writes the numerical metrics of the snapshot to w in
+// the Prometheus text exposition format, as for the WritePrometheus
+// function, with the samples taken at the time of the snapshot.
+func (s *Snapshot) WritePrometheus(w io.Writer, opts *WriteOptions) error {
+	return WritePrometheus(w, s.Values, s.When, opts)
+}
+
+// promSample is a metric to be written by WritePrometheus.
+type promSample struct {
+	k, name string
+	v       interface{}
+}
+
+// WritePrometheus writes the numerical metrics of r to w in the
+// Prometheus text exposition format, in key order. Like MDTable, it
+// accepts any Reader, so, for example, the computed metrics of Wrap
+// can be exported. Metric names are adjusted to be valid Prometheus
+// names, and non-numerical metrics, including nil ones, are omitted,
+// except for *Histogram metrics, which are written as Prometheus
+// histograms. Each metric with descriptive information, see SetMeta,
+// is preceded by HELP and TYPE lines for its Help and Kind. The values
+// are adjusted according to opts. If opts selects Timestamps, each
+// sample carries the time when, which must be representable as a
+// Prometheus timestamp, otherwise WritePrometheus fails with an error
+// wrapping ErrOutOfRange. Since each Prometheus name must be described
+// exactly once, when two metrics, such as "a.b" and "a_b", have the
+// same adjusted name, or one has the name of a sample of a histogram,
+// such as "latency_sum", only the first of them in key order is
+// written. Each metric skipped this way is reported by a trailing
+// comment, and, once the rest of the output has been written, by the
+// returned error, which wraps ErrConflict.
+func WritePrometheus(w io.Writer, r Reader, when time.Time, opts *WriteOptions) error {
+	var ts string
+	if opts != nil && opts.Timestamps {
+		if when.Before(promMinTime) || when.After(promMaxTime) {
+			return fmt.Errorf("timestamp %v: %w", when, ErrOutOfRange)
+		}
+		ts = " " + strconv.FormatInt(when.UnixMilli(), 10)
+	}
+	var samples []promSample
+	var err error
+	r.ForEach(func(k string, v interface{}) bool {
+		if _, ok := v.(*Histogram); !ok {
+			n, nErr := AsNumber(v)
+			if nErr != nil {
+				return true
+			}
+			f, ok, fErr := opts.finite(n)
+			if fErr != nil {
+				err = fmt.Errorf("metric %q: %w", k, fErr)
+				return false
+			}
+			if !ok {
+				return true
+			}
+			if f != n {
+				v = f
+			}
+		}
+		samples = append(samples, promSample{k: k, name: promName(k), v: v})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].k < samples[j].k })
+	claimed := make(map[string]string, len(samples))
+	kept := samples[:0]
+	var conflicts []error
+	for _, p := range samples {
+		names := []string{p.name}
+		if _, ok := p.v.(*Histogram); ok {
+			names = append(names, p.name+"_bucket", p.name+"_sum", p.name+"_count")
+		}
+		var conflict error
+		for _, name := range names {
+			if prev, ok := claimed[name]; ok {
+				conflict = fmt.Errorf("metric %q skipped, Prometheus name %q is used by %q: %w", p.k, name, prev, ErrConflict)
+				break
+			}
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+		for _, name := range names {
+			claimed[name] = p.k
+		}
+		kept = append(kept, p)
+	}
+	bw := bufio.NewWriter(w)
+	for _, p := range kept {
+		meta := metaOf(r, p.k)
+		if h, ok := p.v.(*Histogram); ok {
+			writePromHistogram(bw, p.name, meta.Help, h, ts)
+			continue
+		}
+		f, _ := AsNumber(p.v)
+		writePromHeader(bw, p.name, meta.Help, meta.Kind)
+		fmt.Fprintf(bw, "%s %s%s\n", p.name, promValue(p.v, f), ts)
+	}
+	for _, err := range conflicts {
+		fmt.Fprintf(bw, "# %s\n", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return errors.Join(conflicts...)
+}
+
+// writePromHeader writes the HELP and TYPE lines of a metric, omitting
+// those with no information.
+func writePromHeader(bw *bufio.Writer, name, help string, kind Kind) {
+	if help != "" {
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, promHelp.Replace(help))
+	}
+	if kind != "" {
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, kind)
+	}
+}
+
+// writePromHistogram writes the histogram h, named name, as the
+// cumulative _bucket, and the _sum and _count, samples of a Prometheus
+// histogram. The samples are read from h in a single step, so they
+// are consistent even while h is being updated.
+func writePromHistogram(bw *bufio.Writer, name, help string, h *Histogram, ts string) {
+	writePromHeader(bw, name, help, KindHistogram)
+	bounds, counts, sum := h.state()
+	var total uint64
+	for i, n := range counts {
+		total += n
+		le := "+Inf"
+		if i < len(bounds) {
+			le = promValue(bounds[i], bounds[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{le=%q} %d%s\n", name, le, total, ts)
+	}
+	fmt.Fprintf(bw, "%s_sum %s%s\n", name, promValue(sum, sum), ts)
+	fmt.Fprintf(bw, "%s_count %d%s\n", name, total, ts)
+}
@@ -0,0 +1,156 @@
+package vars
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotWritePrometheus(t *testing.T) {
+	m := New()
+	m.Set("http.requests", int64(1<<60+1))
+	m.Set("ratio", 0.25)
+	m.Set("bad", math.Inf(1))
+	m.Set("label", "x")
+	m.Set("total", uint64(1<<63+1))
+	s := m.Snap()
+	s.When = time.UnixMilli(1700000000123)
+	vs := []struct {
+		opts *WriteOptions
+		want string
+	}{
+		{nil, "bad +Inf\nhttp_requests 1152921504606846977\nratio 0.25\ntotal 9223372036854775809\n"},
+		{&WriteOptions{NonFinite: NonFiniteSkip, Timestamps: true}, "http_requests 1152921504606846977 1700000000123\nratio 0.25 1700000000123\ntotal 9223372036854775809 1700000000123\n"},
+	}
+	for i, v := range vs {
+		var b bytes.Buffer
+		if err := s.WritePrometheus(&b, v.opts); err != nil {
+			t.Fatalf("[%d] WritePrometheus failed: %v", i, err)
+		}
+		if got := b.String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+	s.When = time.Unix(math.MaxInt64/1000+1, 0)
+	if err := s.WritePrometheus(&bytes.Buffer{}, &WriteOptions{Timestamps: true}); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("got err=%v, want=%v", err, ErrOutOfRange)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	m := New()
+	r := NewRegistry(m)
+	r.Register("http.requests", KindCounter, "Requests served.\nBy all handlers.")
+	m.Add("http.requests", 3)
+	m.Set("temp", 21.5)
+	m.Set("missing", nil)
+	m.Set("name", "x")
+	var b bytes.Buffer
+	if err := m.WritePrometheus(&b, &WriteOptions{Counters: ResetOnRead}); err != nil {
+		t.Fatalf("WritePrometheus failed: %v", err)
+	}
+	want := `# HELP http_requests Requests served.\nBy all handlers.
+# TYPE http_requests counter
+http_requests 3
+temp 21.5
+`
+	if got := b.String(); got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+	if got := m.Get("http.requests"); got != 0.0 {
+		t.Errorf("not reset: got=%v", got)
+	}
+}
+
+func TestWritePrometheusHistogramConsistent(t *testing.T) {
+	h := NewHistogram(1, 2)
+	m := New()
+	m.Detail["latency"] = h
+	s := &Snapshot{When: time.Now(), Values: m}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			h.Observe(1)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		var b bytes.Buffer
+		if err := s.WritePrometheus(&b, nil); err != nil {
+			t.Fatalf("WritePrometheus failed: %v", err)
+		}
+		var sum float64
+		var count uint64
+		for _, line := range strings.Split(b.String(), "\n") {
+			fmt.Sscanf(line, "latency_sum %g", &sum)
+			fmt.Sscanf(line, "latency_count %d", &count)
+		}
+		if sum != float64(count) {
+			t.Fatalf("torn sample: sum=%g, count=%d", sum, count)
+		}
+	}
+}
+
+func TestWritePrometheusReader(t *testing.T) {
+	m := New()
+	m.SetMeta("hits", Meta{Kind: KindCounter})
+	m.Set("hits", 3)
+	m.Set("misses", 1)
+	r := Wrap(m.Freeze(), map[string]func(Reader) interface{}{
+		"hit.ratio": func(r Reader) interface{} {
+			return float64(r.Get("hits").(int)) / 4
+		},
+	})
+	var b bytes.Buffer
+	if err := WritePrometheus(&b, r, time.UnixMilli(1000), &WriteOptions{Timestamps: true}); err != nil {
+		t.Fatalf("WritePrometheus failed: %v", err)
+	}
+	want := `hit_ratio 0.75 1000
+# TYPE hits counter
+hits 3 1000
+misses 1 1000
+`
+	if got := b.String(); got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestWritePrometheusConflict(t *testing.T) {
+	vs := []struct {
+		ks   []string
+		want string
+	}{
+		{ks: []string{"a.b", "a_b"}, want: `a_b_bucket{le="+Inf"} 1`},
+		{ks: []string{"latency", "latency_sum"}, want: `latency_bucket{le="+Inf"} 1`},
+	}
+	for _, v := range vs {
+		m := New()
+		m.Observe(v.ks[0], 1)
+		m.Set(v.ks[1], 1)
+		m.Set("other", 2)
+		var b bytes.Buffer
+		err := m.WritePrometheus(&b, nil)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("%q: got=%v, want %v", v.ks, err, ErrConflict)
+		}
+		out := b.String()
+		if !strings.Contains(out, v.want) || !strings.Contains(out, "other 2\n") {
+			t.Errorf("%q: rest of output missing: %q", v.ks, out)
+		}
+		if got := strings.Count(out, "# TYPE"); got != 1 {
+			t.Errorf("%q: got %d TYPE lines, want 1: %q", v.ks, got, out)
+		}
+		if !strings.HasSuffix(out, "# "+err.Error()+"\n") {
+			t.Errorf("%q: conflict not reported: %q", v.ks, out)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package vars
+
+import (
+	"sync"
+	"time"
+)
+
+// RateMeter tracks the rate of change of a single counter over a
+// sliding window of its most recent samples.
+type RateMeter struct {
+	mu      sync.Mutex
+	m       *Metrics
+	k       string
+	window  int
+	total   float64
+	samples []Sample
+
+	// now is the clock used to timestamp samples.
+	now func() time.Time
+}
+
+// NewRateMeter returns a RateMeter for the counter k of m. The rate
+// is computed over the most recent window samples, and window values
+// less than 2 are treated as 2. If m is nil, the RateMeter only
+// tracks its own copy of the counter.
+func NewRateMeter(m *Metrics, k string, window int) *RateMeter {
+	if window < 2 {
+		window = 2
+	}
+	return &RateMeter{
+		m:      m,
+		k:      k,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// Add adds n to the counter and records a sample of its new value.
+func (r *RateMeter) Add(n float64) {
+	r.m.Add(r.k, n)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total += n
+	if len(r.samples) == r.window {
+		copy(r.samples, r.samples[1:])
+		r.samples = r.samples[:r.window-1]
+	}
+	r.samples = append(r.samples, Sample{When: r.now(), Value: r.total})
+}
+
+// PerSecond returns the rate of change of the counter, per second, as
+// the slope of the least squares line through all of the retained
+// samples, so no single sample dominates it. The counter is taken to
+// hold its last value until now, so, once it stops changing, the rate
+// decays toward zero. Until two samples have been recorded, the rate
+// is 0.
+func (r *RateMeter) PerSecond() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.samples) < 2 {
+		return 0
+	}
+	samples := r.samples
+	if last, now := samples[len(samples)-1], r.now(); now.After(last.When) {
+		samples = append(samples[:len(samples):len(samples)], Sample{When: now, Value: last.Value})
+	}
+	return slope(samples)
+}
+
+// slope returns the slope, per second, of the least squares line
+// through the samples, or 0 if they all have the same time.
+func slope(samples []Sample) float64 {
+	t0 := samples[0].When
+	var st, sv float64
+	for _, s := range samples {
+		st += s.When.Sub(t0).Seconds()
+		sv += s.Value
+	}
+	n := float64(len(samples))
+	mt, mv := st/n, sv/n
+	var sxy, sxx float64
+	for _, s := range samples {
+		dt := s.When.Sub(t0).Seconds() - mt
+		sxy += dt * (s.Value - mv)
+		sxx += dt * dt
+	}
+	if sxx == 0 {
+		return 0
+	}
+	return sxy / sxx
+}
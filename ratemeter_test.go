@@ -0,0 +1,41 @@
+package vars
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	m := New()
+	r := NewRateMeter(m, "requests", 3)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	if got := r.PerSecond(); got != 0 {
+		t.Errorf("empty rate: got=%f, want=0", got)
+	}
+	vs := []struct {
+		dt time.Duration
+		n  float64
+		r  float64
+	}{
+		{dt: 0, n: 1, r: 0},
+		{dt: time.Second, n: 2, r: 2},
+		{dt: 2 * time.Second, n: 2, r: 9.0 / 7},
+		{dt: 4 * time.Second, n: 12, r: 17.0 / 7},
+	}
+	for i, v := range vs {
+		now = now.Add(v.dt)
+		r.Add(v.n)
+		if got := r.PerSecond(); math.Abs(got-v.r) > 1e-9 {
+			t.Errorf("[%d] got=%f, want=%f", i, got, v.r)
+		}
+	}
+	now = now.Add(6 * time.Second)
+	if got, want := r.PerSecond(), 53.0/42; math.Abs(got-want) > 1e-9 {
+		t.Errorf("idle: got=%f, want=%f", got, want)
+	}
+	if got, err := m.GetNumber("requests"); err != nil || got != 17 {
+		t.Errorf("counter: got=%g, %v, want=17", got, err)
+	}
+}
@@ -0,0 +1,329 @@
+package vars
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Reader is the read-only view of a set of metrics. It is satisfied
+// by both *Metrics and *Frozen, so the read-side functions of this
+// package, such as MDTable, can be used with live metrics, with
+// frozen ones or with any other implementation.
+type Reader interface {
+	// Get returns the value of metric k, or nil if it is absent.
+	Get(k string) interface{}
+	// Keys returns the names of all of the metrics.
+	Keys() []string
+	// ForEach calls fn for each metric until fn returns false.
+	ForEach(fn func(k string, v interface{}) bool)
+}
+
+// formatted is implemented by Readers that hold display formatters,
+// see SetFormatter.
+type formatted interface {
+	formatter(k string) func(interface{}) string
+}
+
+// formatterOf returns the display formatter of metric k in r, or nil
+// if there is none.
+func formatterOf(r Reader, k string) func(interface{}) string {
+	if f, ok := r.(formatted); ok {
+		return f.formatter(k)
+	}
+	return nil
+}
+
+// described is implemented by Readers that hold descriptive
+// information, see SetMeta.
+type described interface {
+	describe(k string) Meta
+}
+
+// metaOf returns the descriptive information of metric k in r, which
+// is empty if there is none.
+func metaOf(r Reader, k string) Meta {
+	if d, ok := r.(described); ok {
+		return d.describe(k)
+	}
+	return Meta{}
+}
+
+// Keys returns the sorted names of all of the metrics.
+func (m *Metrics) Keys() []string {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	ks := make([]string, 0, len(m.Detail))
+	for k := range m.Detail {
+		ks = append(ks, k)
+	}
+	m.mu.RUnlock()
+	sort.Strings(ks)
+	return ks
+}
+
+// ForEach calls fn for each metric, in key order, until fn returns
+// false. The values passed to fn are those present when ForEach was
+// called: fn is called without holding any lock, so it may itself use
+// the methods of m.
+func (m *Metrics) ForEach(fn func(k string, v interface{}) bool) {
+	if m == nil {
+		return
+	}
+	f := m.Freeze()
+	f.ForEach(fn)
+}
+
+// Export calls fn for each metric, in key order, passing its value
+// along with its numerical form, if it has one. Export stops at, and
+// returns, the first error returned by fn. It is intended as the
+// basis for custom exporters. As for ForEach, fn is called without
+// holding any lock.
+func (m *Metrics) Export(fn func(k string, v interface{}, isNumber bool, num float64) error) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	var err error
+	m.ForEach(func(k string, v interface{}) bool {
+		num, nErr := AsNumber(v)
+		err = fn(k, v, nErr == nil, num)
+		return err == nil
+	})
+	return err
+}
+
+// Floats returns the value of each numerical metric, as converted by
+// AsNumber. Non-numerical metrics are omitted.
+func (m *Metrics) Floats() map[string]float64 {
+	fs := make(map[string]float64)
+	m.RangeNumbers(func(k string, v float64) bool {
+		fs[k] = v
+		return true
+	})
+	return fs
+}
+
+// RangeNumbers calls fn for each numerical metric, in key order, with
+// its value as converted by AsNumber, until fn returns false.
+// Non-numerical metrics are skipped. As for ForEach, the values are
+// those present when RangeNumbers was called, and fn is called
+// without holding any lock.
+func (m *Metrics) RangeNumbers(fn func(k string, v float64) bool) {
+	m.ForEach(func(k string, v interface{}) bool {
+		n, err := AsNumber(v)
+		return err != nil || fn(k, n)
+	})
+}
+
+// typeName returns the name of the type of the metric value v. This
+// is the Go type name, except for the time types, which are named
+// "duration" and "time", and a TimedValue, which is named after the
+// type of its V value.
+func typeName(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case time.Duration:
+		return "duration"
+	case time.Time:
+		return "time"
+	case TimedValue:
+		return typeName(x.V)
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// Types returns the type name of each metric value, for example,
+// "int", "float64", "string", "bool" or "duration".
+func (m *Metrics) Types() map[string]string {
+	types := make(map[string]string)
+	m.ForEach(func(k string, v interface{}) bool {
+		types[k] = typeName(v)
+		return true
+	})
+	return types
+}
+
+// KeysByValueType groups the sorted names of the metrics by the type
+// name of their values, as reported by Types.
+func (m *Metrics) KeysByValueType() map[string][]string {
+	groups := make(map[string][]string)
+	m.ForEach(func(k string, v interface{}) bool {
+		t := typeName(v)
+		groups[t] = append(groups[t], k)
+		return true
+	})
+	return groups
+}
+
+// describe returns the descriptive information of metric k.
+func (m *Metrics) describe(k string) Meta {
+	meta, _ := m.GetMeta(k)
+	return meta
+}
+
+// formatter returns the display formatter of metric k.
+func (m *Metrics) formatter(k string) func(interface{}) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.display(k)
+}
+
+// display returns the display formatter of metric k: its own
+// formatter, if it has one, or else the one implied by its unit. The
+// caller must hold m.mu.
+func (m *Metrics) display(k string) func(interface{}) string {
+	if f := m.formats[k]; f != nil {
+		return f
+	}
+	return unitFormatter(m.meta[k].Unit)
+}
+
+// Frozen holds an immutable copy of a set of metrics. It implements
+// Reader.
+type Frozen struct {
+	when    time.Time
+	keys    []string
+	detail  map[string]interface{}
+	formats map[string]func(interface{}) string
+	meta    map[string]Meta
+}
+
+// Freeze returns an immutable copy of the current metric values.
+func (m *Metrics) Freeze() *Frozen {
+	s := m.snap(false)
+	f := &Frozen{
+		when:    s.When,
+		detail:  s.Values.Detail,
+		formats: s.Values.formats,
+		meta:    s.Values.meta,
+	}
+	for k := range f.detail {
+		f.keys = append(f.keys, k)
+	}
+	sort.Strings(f.keys)
+	return f
+}
+
+// When returns the time the metrics were frozen.
+func (f *Frozen) When() time.Time {
+	return f.when
+}
+
+// Get returns the frozen value of metric k.
+func (f *Frozen) Get(k string) interface{} {
+	return f.detail[k]
+}
+
+// Keys returns the sorted names of all of the frozen metrics.
+func (f *Frozen) Keys() []string {
+	return append([]string(nil), f.keys...)
+}
+
+// ForEach calls fn for each frozen metric, in key order, until fn
+// returns false.
+func (f *Frozen) ForEach(fn func(k string, v interface{}) bool) {
+	for _, k := range f.keys {
+		if !fn(k, f.detail[k]) {
+			return
+		}
+	}
+}
+
+// formatter returns the display formatter of metric k.
+func (f *Frozen) formatter(k string) func(interface{}) string {
+	if fn := f.formats[k]; fn != nil {
+		return fn
+	}
+	return unitFormatter(f.meta[k].Unit)
+}
+
+// describe returns the descriptive information of metric k.
+func (f *Frozen) describe(k string) Meta {
+	return f.meta[k]
+}
+
+// wrapped is a Reader that combines the metrics of a base Reader with
+// computed ones.
+type wrapped struct {
+	base     Reader
+	computed map[string]func(Reader) interface{}
+}
+
+// Wrap returns a Reader holding all of the metrics of base along with
+// the computed metrics. The value of each computed metric is the
+// value returned by its function, called with base, each time the
+// metric is read. This is useful for deriving values, such as ratios
+// of counters, which would be stale if stored. A computed metric
+// hides any base metric with the same name.
+func Wrap(base Reader, computed map[string]func(Reader) interface{}) Reader {
+	return &wrapped{base: base, computed: computed}
+}
+
+// Get returns the value of metric k.
+func (w *wrapped) Get(k string) interface{} {
+	if fn, ok := w.computed[k]; ok {
+		return fn(w.base)
+	}
+	return w.base.Get(k)
+}
+
+// Keys returns the sorted names of all of the base and computed
+// metrics.
+func (w *wrapped) Keys() []string {
+	var ks []string
+	for _, k := range w.base.Keys() {
+		if _, ok := w.computed[k]; !ok {
+			ks = append(ks, k)
+		}
+	}
+	for k := range w.computed {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// ForEach calls fn for each base and computed metric, in key order,
+// until fn returns false.
+func (w *wrapped) ForEach(fn func(k string, v interface{}) bool) {
+	vs := make(map[string]interface{})
+	w.base.ForEach(func(k string, v interface{}) bool {
+		vs[k] = v
+		return true
+	})
+	for k, c := range w.computed {
+		vs[k] = c(w.base)
+	}
+	ks := make([]string, 0, len(vs))
+	for k := range vs {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		if !fn(k, vs[k]) {
+			return
+		}
+	}
+}
+
+// formatter returns the display formatter of base metric k. Computed
+// metrics have no formatter.
+func (w *wrapped) formatter(k string) func(interface{}) string {
+	if _, ok := w.computed[k]; ok {
+		return nil
+	}
+	return formatterOf(w.base, k)
+}
+
+// describe returns the descriptive information of base metric k.
+// Computed metrics have none.
+func (w *wrapped) describe(k string) Meta {
+	if _, ok := w.computed[k]; ok {
+		return Meta{}
+	}
+	return metaOf(w.base, k)
+}
@@ -0,0 +1,240 @@
+package vars
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeReader is a minimal Reader implementation.
+type fakeReader map[string]interface{}
+
+func (f fakeReader) Get(k string) interface{} { return f[k] }
+
+func (f fakeReader) Keys() (ks []string) {
+	for k := range f {
+		ks = append(ks, k)
+	}
+	return
+}
+
+func (f fakeReader) ForEach(fn func(k string, v interface{}) bool) {
+	for k, v := range f {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	f := m.Freeze()
+	m.Set("a", 2)
+	m.Set("c", 3)
+	if got := f.Get("a"); got != 1 {
+		t.Errorf("frozen value changed: got=%v, want=1", got)
+	}
+	if got, want := strings.Join(f.Keys(), ","), "a,b"; got != want {
+		t.Errorf("frozen keys: got=%q, want=%q", got, want)
+	}
+	if got, want := strings.Join(m.Keys(), ","), "a,b,c"; got != want {
+		t.Errorf("live keys: got=%q, want=%q", got, want)
+	}
+	var seen []string
+	m.ForEach(func(k string, v interface{}) bool {
+		seen = append(seen, k)
+		m.Set("d", 4)
+		return k != "b"
+	})
+	if got, want := strings.Join(seen, ","), "a,b"; got != want {
+		t.Errorf("ForEach stopped wrongly: got=%q, want=%q", got, want)
+	}
+}
+
+func TestMDTableReader(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	when := time.Now()
+	for i, r := range []Reader{m, m.Freeze(), fakeReader{"a": 1, "b": "two"}} {
+		d, err := MDTable(r, when, nil)
+		if err != nil {
+			t.Fatalf("[%d] MDTable failed: %v", i, err)
+		}
+		if got, want := strings.Join(strings.Split(string(d), "\n")[2:4], ","), "a | 1,b | two"; got != want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	m.Set("c", 3.5)
+	var got []string
+	err := m.Export(func(k string, v interface{}, isNumber bool, num float64) error {
+		got = append(got, fmt.Sprint(k, "=", v, ":", isNumber, ":", num))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if want := "a=1:true:1,b=two:false:0,c=3.5:true:3.5"; strings.Join(got, ",") != want {
+		t.Errorf("got=%q, want=%q", strings.Join(got, ","), want)
+	}
+	stop := errors.New("stop")
+	n := 0
+	if err := m.Export(func(string, interface{}, bool, float64) error {
+		n++
+		return stop
+	}); err != stop || n != 1 {
+		t.Errorf("got err=%v after %d calls, want=%v after 1", err, n, stop)
+	}
+}
+
+func TestTypes(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", 2.5)
+	m.Set("c", "three")
+	m.Set("d", true)
+	m.AddDuration("e", time.Second)
+	m.SetTimestamped("f", int64(6), time.Now())
+	got := m.Types()
+	want := map[string]string{"a": "int", "b": "float64", "c": "string", "d": "bool", "e": "duration", "f": "int64"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
+
+func TestFloats(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	m.Set("c", 3.5)
+	if got, want := fmt.Sprint(m.Floats()), "map[a:1 c:3.5]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	m := New()
+	m.Set("errors", 3)
+	m.Set("requests", 12)
+	r := Wrap(m, map[string]func(Reader) interface{}{
+		"error_rate": func(b Reader) interface{} {
+			e, _ := AsNumber(b.Get("errors"))
+			n, _ := AsNumber(b.Get("requests"))
+			return e / n
+		},
+	})
+	if got, want := strings.Join(r.Keys(), ","), "error_rate,errors,requests"; got != want {
+		t.Errorf("keys: got=%q, want=%q", got, want)
+	}
+	if got := r.Get("error_rate"); got != 0.25 {
+		t.Errorf("computed: got=%v, want=0.25", got)
+	}
+	m.Add("requests", 3)
+	var seen []string
+	r.ForEach(func(k string, v interface{}) bool {
+		seen = append(seen, fmt.Sprint(k, "=", v))
+		return true
+	})
+	if got, want := strings.Join(seen, ","), "error_rate=0.2,errors=3,requests=15"; got != want {
+		t.Errorf("ForEach: got=%q, want=%q", got, want)
+	}
+}
+
+// reentrant is a metric value whose String method uses the metrics
+// holding it.
+type reentrant struct {
+	m *Metrics
+}
+
+func (r reentrant) String() string {
+	r.m.Inc("calls")
+	return "reentrant"
+}
+
+func TestNoCallbacksUnderLock(t *testing.T) {
+	m := New()
+	m.Set("r", reentrant{m})
+	m.Set("f", 1)
+	m.SetFormatter("f", func(v interface{}) string {
+		m.Inc("calls")
+		return fmt.Sprint(v)
+	})
+	vs := []struct {
+		name string
+		fn   func()
+	}{
+		{"DumpMDTable", func() { m.DumpMDTable() }},
+		{"MDTable", func() { MDTable(m, time.Now(), nil) }},
+		{"String", func() { _ = m.String() }},
+		{"SnapshotJSON", func() { m.SnapshotJSON() }},
+		{"ForEach", func() {
+			m.ForEach(func(k string, v interface{}) bool {
+				m.Inc("calls")
+				return true
+			})
+		}},
+		{"Export", func() {
+			m.Export(func(string, interface{}, bool, float64) error {
+				m.Inc("calls")
+				return nil
+			})
+		}},
+		{"WaitForValue", func() {
+			m.WaitForValue(context.Background(), "f", func(interface{}) bool {
+				m.Inc("calls")
+				return true
+			})
+		}},
+	}
+	for _, v := range vs {
+		done := make(chan struct{})
+		go func() {
+			v.fn()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s deadlocked", v.name)
+		}
+	}
+}
+
+func TestRangeNumbers(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "two")
+	m.Set("c", 2.5)
+	m.Set("d", 4)
+	var seen []string
+	m.RangeNumbers(func(k string, v float64) bool {
+		seen = append(seen, fmt.Sprint(k, "=", v))
+		return k != "c"
+	})
+	if got, want := strings.Join(seen, ","), "a=1,c=2.5"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestKeysByValueType(t *testing.T) {
+	m := New()
+	m.Set("b", 1)
+	m.Set("a", 2)
+	m.Set("label", "x")
+	m.Set("up", time.Second)
+	got := fmt.Sprint(m.KeysByValueType())
+	if want := "map[duration:[up] int:[a b] string:[label]]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package vars
+
+import (
+	"runtime"
+	"time"
+)
+
+// RuntimeCollector is a Collector of statistics of the Go runtime.
+// It sets these metrics:
+//
+//	go.goroutines    the number of goroutines
+//	go.heap_alloc    the bytes of allocated heap objects
+//	go.heap_sys      the bytes of heap memory obtained from the OS
+//	go.heap_objects  the number of allocated heap objects
+//	go.num_gc        the number of completed GC cycles
+//	go.gc_pauses     the cumulative GC stop-the-world pause time
+//
+// The byte counts have the Unit UnitBytes, and go.gc_pauses is a
+// time.Duration. The cumulative go.num_gc and go.gc_pauses have a
+// Kind of KindCounter, and the others KindGauge. Since it calls
+// runtime.ReadMemStats, which briefly stops the world, it is best
+// collected at modest intervals.
+type RuntimeCollector struct{}
+
+// Collect sets the runtime metrics in m.
+func (RuntimeCollector) Collect(m *Metrics) error {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	gauge, bytes, counter := Meta{Kind: KindGauge}, Meta{Kind: KindGauge, Unit: UnitBytes}, Meta{Kind: KindCounter}
+	vs := []struct {
+		k    string
+		v    interface{}
+		meta Meta
+	}{
+		{"go.goroutines", runtime.NumGoroutine(), gauge},
+		{"go.heap_alloc", int64(ms.HeapAlloc), bytes},
+		{"go.heap_sys", int64(ms.HeapSys), bytes},
+		{"go.heap_objects", ms.HeapObjects, gauge},
+		{"go.num_gc", ms.NumGC, counter},
+		{"go.gc_pauses", time.Duration(ms.PauseTotalNs), counter},
+	}
+	for _, v := range vs {
+		if err := m.setCollected(v.k, v.v, v.meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package vars
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	m := New()
+	m.AddCollector(RuntimeCollector{})
+	s := m.Snap()
+	if n, err := s.GetNumber("go.goroutines"); err != nil || n < 1 {
+		t.Errorf("goroutines: got=%g, %v", n, err)
+	}
+	if n, ok := AsInt64(s.Get("go.heap_alloc")); !ok || n <= 0 {
+		t.Errorf("heap_alloc: got=%#v", s.Get("go.heap_alloc"))
+	}
+	if meta, _ := s.Values.GetMeta("go.heap_sys"); meta.Unit != UnitBytes {
+		t.Errorf("heap_sys unit: got=%q, want=%q", meta.Unit, UnitBytes)
+	}
+	if _, ok := s.Get("go.gc_pauses").(time.Duration); !ok {
+		t.Errorf("gc_pauses: got %T, want time.Duration", s.Get("go.gc_pauses"))
+	}
+	for _, k := range []string{"go.heap_objects", "go.num_gc"} {
+		if _, err := s.GetNumber(k); err != nil {
+			t.Errorf("%s: %v", k, err)
+		}
+	}
+}
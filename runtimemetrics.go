@@ -0,0 +1,102 @@
+package vars
+
+import (
+	"math"
+	"runtime/metrics"
+	"strings"
+)
+
+// RuntimeMetricsCollector is a Collector of all of the metrics
+// supported by the runtime/metrics package of the running Go release,
+// so new runtime telemetry is available without changes to this
+// package. A runtime metric is set under its name with the leading
+// slash replaced by "go.", and its other slashes and the colon before
+// its unit replaced by dots. For example, /gc/heap/allocs:bytes is
+// set as go.gc.heap.allocs.bytes. The Meta of each metric holds its
+// runtime description as Help, its unit as Unit, and a Kind of
+// KindCounter for cumulative metrics, KindHistogram for histograms,
+// and KindGauge otherwise.
+//
+// Histogram metrics are set as a *Histogram. Since the runtime does
+// not record the sum of the values of a histogram, the Sum of one is
+// estimated from the midpoints of its buckets.
+type RuntimeMetricsCollector struct{}
+
+// runtimeMetricKey returns the metric key for the runtime/metrics
+// metric name.
+func runtimeMetricKey(name string) string {
+	return "go." + strings.NewReplacer("/", ".", ":", ".").Replace(strings.TrimPrefix(name, "/"))
+}
+
+// Collect sets the runtime/metrics metrics in m.
+func (RuntimeMetricsCollector) Collect(m *Metrics) error {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+	for i, s := range samples {
+		d := descs[i]
+		var v interface{}
+		meta := Meta{Kind: KindGauge, Help: d.Description}
+		if d.Cumulative {
+			meta.Kind = KindCounter
+		}
+		if j := strings.LastIndexByte(d.Name, ':'); j >= 0 {
+			meta.Unit = d.Name[j+1:]
+		}
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			v = s.Value.Uint64()
+		case metrics.KindFloat64:
+			v = s.Value.Float64()
+		case metrics.KindFloat64Histogram:
+			v = fromRuntimeHistogram(s.Value.Float64Histogram())
+			meta.Kind = KindHistogram
+		default:
+			// Unsupported by this Go release.
+			continue
+		}
+		k := runtimeMetricKey(d.Name)
+		if err := m.setCollected(k, v, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fromRuntimeHistogram converts a runtime/metrics histogram to a
+// Histogram. The runtime buckets are half open, [lo, hi), which this
+// treats as the inclusive upper bound so the bucket counts carry over
+// unchanged.
+func fromRuntimeHistogram(rh *metrics.Float64Histogram) *Histogram {
+	bounds := rh.Buckets[1:]
+	overflow := uint64(0)
+	counts := append([]uint64(nil), rh.Counts...)
+	if n := len(bounds); n != 0 && math.IsInf(bounds[n-1], 1) {
+		bounds = bounds[:n-1]
+		overflow = counts[n-1]
+		counts = counts[:n-1]
+	}
+	h := &Histogram{
+		bounds: append([]float64(nil), bounds...),
+		counts: append(counts, overflow),
+	}
+	for i, n := range rh.Counts {
+		if n == 0 {
+			continue
+		}
+		lo, hi := rh.Buckets[i], rh.Buckets[i+1]
+		mid := (lo + hi) / 2
+		switch {
+		case math.IsInf(lo, -1):
+			mid = hi
+		case math.IsInf(hi, 1):
+			mid = lo
+		}
+		h.count += n
+		h.sum += mid * float64(n)
+	}
+	return h
+}
@@ -0,0 +1,38 @@
+package vars
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+)
+
+func TestRuntimeMetricsCollector(t *testing.T) {
+	m := New()
+	if err := (RuntimeMetricsCollector{}).Collect(m); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if n, err := m.GetNumber("go.sched.goroutines.goroutines"); err != nil || n < 1 {
+		t.Errorf("goroutines: got=%g, %v", n, err)
+	}
+	meta, _ := m.GetMeta("go.gc.heap.allocs.bytes")
+	if meta.Kind != KindCounter || meta.Unit != UnitBytes || meta.Help == "" {
+		t.Errorf("allocs meta: got=%#v", meta)
+	}
+	if _, ok := m.Get("go.sched.latencies.seconds").(*Histogram); !ok {
+		t.Errorf("latencies: got %T, want *Histogram", m.Get("go.sched.latencies.seconds"))
+	}
+}
+
+func TestFromRuntimeHistogram(t *testing.T) {
+	h := fromRuntimeHistogram(&metrics.Float64Histogram{
+		Counts:  []uint64{1, 2, 3, 4},
+		Buckets: []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)},
+	})
+	bounds, counts := h.Buckets()
+	if len(bounds) != 3 || bounds[2] != 4 || len(counts) != 4 || counts[0] != 1 || counts[3] != 4 {
+		t.Errorf("buckets: got=%v, %v", bounds, counts)
+	}
+	if got, want := h.Sum(), 1+2*1.5+3*3+4*4.0; h.Count() != 10 || got != want {
+		t.Errorf("count=%d sum=%g, want count=10 sum=%g", h.Count(), got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package vars
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Resample returns the numerical value of metric k at each point of
+// the time grid from, from+step, from+2*step, ... up to and including
+// to. The value at a grid point is the most recent value recorded at
+// or before that time, as determined by Infer.
+func Resample(snaps []*Snapshot, k string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	if step <= 0 {
+		return nil, ErrBadStep
+	}
+	var samples []Sample
+	for t := from; !t.After(to); t = t.Add(step) {
+		_, v, err := Infer(snaps, t, k)
+		if err != nil {
+			return nil, fmt.Errorf("error for %q at %v: %w", k, t, err)
+		}
+		n, err := AsNumber(v)
+		if err != nil {
+			return nil, fmt.Errorf("error for %q at %v: %w", k, t, err)
+		}
+		samples = append(samples, Sample{When: t, Value: n})
+	}
+	return samples, nil
+}
+
+// Stuck reports whether the numerical metric k has held the same
+// value across all of the snapshots recorded in the within period
+// leading up to now. It returns false if there is no numerical value
+// for k at the start of that period.
+func Stuck(snaps []*Snapshot, k string, within time.Duration, now time.Time) bool {
+	start := now.Add(-within)
+	_, v, err := Infer(snaps, start, k)
+	if err != nil {
+		return false
+	}
+	n, err := AsNumber(v)
+	if err != nil {
+		return false
+	}
+	i := sort.Search(len(snaps), func(a int) bool {
+		return snaps[a].When.After(start)
+	})
+	for ; i < len(snaps) && !snaps[i].When.After(now); i++ {
+		x, ok := snaps[i].Values.Detail[k]
+		if !ok {
+			continue
+		}
+		if y, err := AsNumber(x); err != nil || y != n {
+			return false
+		}
+	}
+	return true
+}
+
+// AlignSeries resamples the numerical metric k of two histories, a
+// and b, onto the same time grid, as described for Resample. The
+// returned values av[i] and bv[i] are those of each history at
+// times[i].
+func AlignSeries(a, b []*Snapshot, k string, step time.Duration, from, to time.Time) (times []time.Time, av, bv []float64, err error) {
+	as, err := Resample(a, k, from, to, step)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("first series: %w", err)
+	}
+	bs, err := Resample(b, k, from, to, step)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("second series: %w", err)
+	}
+	for i, s := range as {
+		times = append(times, s.When)
+		av = append(av, s.Value)
+		bv = append(bv, bs[i].Value)
+	}
+	return
+}
+
+// sparks are the characters of a sparkline, from lowest to highest.
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline returns a string of width Unicode block characters
+// depicting the numerical metric k over the time range from to to.
+// The series is sampled, as for Resample, at width evenly spaced
+// points, the first at from and the last at to, and the characters
+// are scaled between the minimum and maximum finite values sampled.
+// Infinite values are drawn at the extremes of the scale, and NaN
+// values as a space.
+func Sparkline(snaps []*Snapshot, k string, from, to time.Time, width int) (string, error) {
+	if width <= 0 {
+		return "", fmt.Errorf("invalid sparkline width %d", width)
+	}
+	values := make([]float64, width)
+	for i := range values {
+		t := to
+		if i < width-1 {
+			t = from.Add(time.Duration(float64(to.Sub(from)) * float64(i) / float64(width-1)))
+		}
+		_, v, err := Infer(snaps, t, k)
+		if err != nil {
+			return "", fmt.Errorf("error for %q at %v: %w", k, t, err)
+		}
+		if values[i], err = AsNumber(v); err != nil {
+			return "", fmt.Errorf("error for %q at %v: %w", k, t, err)
+		}
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsInf(v, 0) && !math.IsNaN(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	line := make([]rune, width)
+	for i, v := range values {
+		switch {
+		case math.IsNaN(v):
+			line[i] = ' '
+		case math.IsInf(v, 1):
+			line[i] = sparks[len(sparks)-1]
+		case math.IsInf(v, -1) || hi <= lo:
+			line[i] = sparks[0]
+		default:
+			line[i] = sparks[int((v-lo)/(hi-lo)*float64(len(sparks)-1)+0.5)]
+		}
+	}
+	return string(line), nil
+}
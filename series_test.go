@@ -0,0 +1,120 @@
+package vars
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// testSeries returns snapshots of a metric, "x", holding the values
+// vs recorded at the times base+dts[i].
+func testSeries(base time.Time, dts []time.Duration, vs []interface{}) []*Snapshot {
+	var snaps []*Snapshot
+	for i, dt := range dts {
+		m := New()
+		m.Set("x", vs[i])
+		snaps = append(snaps, &Snapshot{When: base.Add(dt), Values: m})
+	}
+	return snaps
+}
+
+func TestResample(t *testing.T) {
+	base := time.Now()
+	snaps := testSeries(base, []time.Duration{0, 3 * time.Second, 4 * time.Second}, []interface{}{1, 2, 3})
+	samples, err := Resample(snaps, "x", base, base.Add(5*time.Second), 2*time.Second)
+	if err != nil {
+		t.Fatalf("Resample failed: %v", err)
+	}
+	want := []float64{1, 1, 3}
+	if len(samples) != len(want) {
+		t.Fatalf("bad number of samples: got=%d, want=%d", len(samples), len(want))
+	}
+	for i, s := range samples {
+		if s.Value != want[i] || !s.When.Equal(base.Add(time.Duration(i)*2*time.Second)) {
+			t.Errorf("[%d] got=%v, want=%g", i, s, want[i])
+		}
+	}
+	if _, err := Resample(snaps, "x", base, base, 0); err != ErrBadStep {
+		t.Errorf("zero step: got=%v, want=%v", err, ErrBadStep)
+	}
+	if _, err := Resample(snaps, "x", base.Add(-time.Second), base, time.Second); err == nil {
+		t.Error("resampling before the first snapshot succeeded")
+	}
+}
+
+func TestStuck(t *testing.T) {
+	base := time.Now()
+	dts := []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 8 * time.Minute}
+	snaps := testSeries(base, dts, []interface{}{1, 2, 3, 3, 3})
+	vs := []struct {
+		within time.Duration
+		now    time.Duration
+		want   bool
+	}{
+		{within: time.Minute, now: 150 * time.Second, want: false},
+		{within: time.Minute, now: 4 * time.Minute, want: true},
+		{within: 5 * time.Minute, now: 9 * time.Minute, want: true},
+		{within: 450 * time.Second, now: 9 * time.Minute, want: false},
+		{within: 10 * time.Minute, now: 9 * time.Minute, want: false},
+	}
+	for i, v := range vs {
+		if got := Stuck(snaps, "x", v.within, base.Add(v.now)); got != v.want {
+			t.Errorf("[%d] got=%v, want=%v", i, got, v.want)
+		}
+	}
+}
+
+func TestAlignSeries(t *testing.T) {
+	base := time.Now()
+	a := testSeries(base, []time.Duration{0, 2 * time.Second}, []interface{}{1, 5})
+	b := testSeries(base.Add(-time.Second), []time.Duration{0, 2 * time.Second}, []interface{}{2, 3})
+	times, av, bv, err := AlignSeries(a, b, "x", time.Second, base, base.Add(2*time.Second))
+	if err != nil {
+		t.Fatalf("AlignSeries failed: %v", err)
+	}
+	if got, want := fmt.Sprint(av, bv), "[1 1 5] [2 3 3]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	if len(times) != 3 || !times[2].Equal(base.Add(2*time.Second)) {
+		t.Errorf("bad times: %v", times)
+	}
+	if _, _, _, err := AlignSeries(a, b, "x", time.Second, base.Add(-time.Second), base); err == nil {
+		t.Error("unexpected success before the first series starts")
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	base := time.Now()
+	var dts []time.Duration
+	var vs []interface{}
+	for i := 0; i < 8; i++ {
+		dts = append(dts, time.Duration(i)*time.Second)
+		vs = append(vs, 10+i)
+	}
+	snaps := testSeries(base, dts, vs)
+	line, err := Sparkline(snaps, "x", base, base.Add(7*time.Second), 8)
+	if err != nil {
+		t.Fatalf("Sparkline failed: %v", err)
+	}
+	if want := "▁▂▃▄▅▆▇█"; line != want {
+		t.Errorf("got=%q, want=%q", line, want)
+	}
+	if line, err := Sparkline(snaps, "x", base, base.Add(7*time.Second), 3); err != nil || line != "▁▄█" {
+		t.Errorf("narrow: got=%q, %v, want=\"▁▄█\"", line, err)
+	}
+	if line, err := Sparkline(snaps, "x", base, base, 4); err != nil || line != "▁▁▁▁" {
+		t.Errorf("flat: got=%q, %v, want=\"▁▁▁▁\"", line, err)
+	}
+	rising := testSeries(base, []time.Duration{0, 3 * time.Second, 6 * time.Second, 10 * time.Second}, []interface{}{1, 4, 7, 10})
+	if line, err := Sparkline(rising, "x", base, base.Add(10*time.Second), 4); err != nil || line != "▁▃▆█" {
+		t.Errorf("rising: got=%q, %v, want=\"▁▃▆█\"", line, err)
+	}
+	odd := testSeries(base, []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second}, []interface{}{1, math.Inf(1), math.NaN(), 2})
+	if line, err := Sparkline(odd, "x", base, base.Add(3*time.Second), 4); err != nil || line != "▁█ █" {
+		t.Errorf("non-finite: got=%q, %v, want=\"▁█ █\"", line, err)
+	}
+	if _, err := Sparkline(snaps, "x", base, base, 0); err == nil {
+		t.Error("zero width accepted")
+	}
+}
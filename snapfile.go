@@ -0,0 +1,257 @@
+package vars
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// The snapshot file format begins with a header, snapMagic followed
+// by the big-endian uint32 snapVersion. The header is followed by
+// one record per snapshot, in time order. Each record is a big-endian
+// uint32 length, n, the big-endian int64 UnixNano time of the
+// snapshot, and n bytes of the metric values encoded as a JSON
+// object. The fixed size record prefix lets a reader index a file
+// without decoding any of its metric values. snapVersion is
+// increased whenever this format changes incompatibly, independently
+// of FormatVersion.
+const (
+	snapMagic      = "VARS"
+	snapVersion    = 1
+	snapHeaderLen  = len(snapMagic) + 4
+	snapRecordHead = 4 + 8
+)
+
+// checkSnapVersion confirms a snapshot file of version v can be read.
+func checkSnapVersion(v uint32) error {
+	if v != snapVersion {
+		return fmt.Errorf("got snapshot file version %d, want %d: %w", v, snapVersion, ErrVersion)
+	}
+	return nil
+}
+
+// SnapshotWriter writes snapshots to an io.Writer in the format read
+// by SnapshotReader.
+type SnapshotWriter struct {
+	w    io.Writer
+	last time.Time
+	n    int
+}
+
+// NewSnapshotWriter writes the snapshot file header to w and returns
+// a SnapshotWriter for appending snapshots to it.
+func NewSnapshotWriter(w io.Writer) (*SnapshotWriter, error) {
+	head := make([]byte, snapHeaderLen)
+	copy(head, snapMagic)
+	binary.BigEndian.PutUint32(head[len(snapMagic):], snapVersion)
+	if _, err := w.Write(head); err != nil {
+		return nil, err
+	}
+	return &SnapshotWriter{w: w}, nil
+}
+
+// Write appends s to the file. Snapshots must be written in time
+// order: a snapshot older than the previous one is rejected with an
+// error wrapping ErrUnsorted.
+func (sw *SnapshotWriter) Write(s *Snapshot) error {
+	if sw.n != 0 && s.When.Before(sw.last) {
+		return fmt.Errorf("snapshot at %v follows %v: %w", s.When, sw.last, ErrUnsorted)
+	}
+	values := encodeValues(s.Values.values())
+	rec := make([]byte, snapRecordHead, snapRecordHead+len(values))
+	binary.BigEndian.PutUint32(rec, uint32(len(values)))
+	binary.BigEndian.PutUint64(rec[4:], uint64(s.When.UnixNano()))
+	if _, err := sw.w.Write(append(rec, values...)); err != nil {
+		return err
+	}
+	sw.last = s.When
+	sw.n++
+	return nil
+}
+
+// snapEntry locates a snapshot record in a snapshot file.
+type snapEntry struct {
+	when time.Time
+	off  int64
+	n    uint32
+}
+
+// SnapshotReader provides access to the snapshots of a file written
+// by SnapshotWriter. Only an index of the snapshot times is held in
+// memory, the snapshots themselves are read from the file when they
+// are needed, so a SnapshotReader can be used with histories that
+// are too large to load.
+type SnapshotReader struct {
+	r     io.ReaderAt
+	c     io.Closer
+	index []snapEntry
+}
+
+// OpenSnapshotReader opens the named snapshot file. The returned
+// SnapshotReader should be closed when it is no longer needed.
+func OpenSnapshotReader(name string) (*SnapshotReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sr, err := NewSnapshotReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sr.c = f
+	return sr, nil
+}
+
+// NewSnapshotReader indexes the snapshot data of r, which holds size
+// bytes. Data of a different snapshot file version is rejected with
+// an error wrapping ErrVersion, and data holding snapshots that are
+// out of time order with one wrapping ErrUnsorted. An incomplete final
+// record, as left by a writer that was interrupted, is not indexed, so
+// the snapshots written before it remain readable.
+func NewSnapshotReader(r io.ReaderAt, size int64) (*SnapshotReader, error) {
+	head := make([]byte, snapHeaderLen)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, fmt.Errorf("bad snapshot header: %w", err)
+	}
+	if string(head[:len(snapMagic)]) != snapMagic {
+		return nil, errors.New("not a snapshot file")
+	}
+	if err := checkSnapVersion(binary.BigEndian.Uint32(head[len(snapMagic):])); err != nil {
+		return nil, err
+	}
+	sr := &SnapshotReader{r: r}
+	rec := make([]byte, snapRecordHead)
+	for off := int64(snapHeaderLen); off < size; {
+		if off+snapRecordHead > size {
+			break
+		}
+		if _, err := r.ReadAt(rec, off); err != nil {
+			return nil, fmt.Errorf("snapshot %d at offset %d: %w", len(sr.index), off, err)
+		}
+		e := snapEntry{
+			when: time.Unix(0, int64(binary.BigEndian.Uint64(rec[4:]))),
+			off:  off + snapRecordHead,
+			n:    binary.BigEndian.Uint32(rec),
+		}
+		if e.off+int64(e.n) > size {
+			break
+		}
+		if i := len(sr.index); i != 0 && e.when.Before(sr.index[i-1].when) {
+			return nil, fmt.Errorf("snapshot %d at %v follows %v: %w", i, e.when, sr.index[i-1].when, ErrUnsorted)
+		}
+		sr.index = append(sr.index, e)
+		off = e.off + int64(e.n)
+	}
+	return sr, nil
+}
+
+// Close closes the file opened by OpenSnapshotReader. It does nothing
+// for a SnapshotReader returned by NewSnapshotReader.
+func (sr *SnapshotReader) Close() error {
+	if sr.c == nil {
+		return nil
+	}
+	return sr.c.Close()
+}
+
+// Len returns the number of snapshots in the file.
+func (sr *SnapshotReader) Len() int {
+	return len(sr.index)
+}
+
+// When returns the time of snapshot i.
+func (sr *SnapshotReader) When(i int) time.Time {
+	return sr.index[i].when
+}
+
+// Search returns the index of the first snapshot taken after t, or
+// Len() if there is none.
+func (sr *SnapshotReader) Search(t time.Time) int {
+	return sort.Search(len(sr.index), func(i int) bool {
+		return sr.index[i].when.After(t)
+	})
+}
+
+// Snapshot reads snapshot i from the file. The metric values are
+// decoded as described for Snapshot.UnmarshalJSON.
+func (sr *SnapshotReader) Snapshot(i int) (*Snapshot, error) {
+	e := sr.index[i]
+	data := make([]byte, e.n)
+	if _, err := sr.r.ReadAt(data, e.off); err != nil {
+		return nil, fmt.Errorf("snapshot %d: %w", i, err)
+	}
+	detail, err := decodeValues(data)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %d: %w", i, err)
+	}
+	return &Snapshot{When: e.when, Values: &Metrics{Detail: detail}}, nil
+}
+
+// Range calls fn, in time order, for each snapshot taken in the time
+// range from to to, including from but excluding to, until fn returns
+// an error. Range returns the first error returned by fn, or
+// encountered reading the file.
+func (sr *SnapshotReader) Range(from, to time.Time, fn func(*Snapshot) error) error {
+	i := sort.Search(len(sr.index), func(i int) bool {
+		return !sr.index[i].when.Before(from)
+	})
+	for ; i < len(sr.index) && sr.index[i].when.Before(to); i++ {
+		s, err := sr.Snapshot(i)
+		if err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InferFile is the same as Infer, but it reads the snapshots from sr.
+// Only the snapshots up to the one holding the returned value are
+// read.
+func InferFile(sr *SnapshotReader, t time.Time, k string) (index int, v interface{}, err error) {
+	for i := sr.Search(t) - 1; i >= 0; i-- {
+		s, err := sr.Snapshot(i)
+		if err != nil {
+			return 0, nil, err
+		}
+		if v, ok := s.Values.Detail[k]; ok {
+			return i, v, nil
+		}
+	}
+	return 0, nil, ErrNotFound
+}
+
+// ExtractNumbersFile is the same as ExtractNumbers, but it reads the
+// snapshots from sr, one at a time, so only the extracted numbers are
+// held in memory.
+func ExtractNumbersFile(sr *SnapshotReader, timeunits time.Duration, from, to time.Time, vars []string) ([][]float64, error) {
+	e, err := startExtraction(timeunits, from, to, vars, func(k string) (interface{}, error) {
+		_, v, err := InferFile(sr, from, k)
+		return v, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := sr.Search(from); i < sr.Len() && !e.done; i++ {
+		s, err := sr.Snapshot(i)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.step(i, s); err != nil {
+			return nil, err
+		}
+	}
+	return e.lines, nil
+}
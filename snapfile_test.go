@@ -0,0 +1,105 @@
+package vars
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotFile(t *testing.T) {
+	base := time.Unix(1000, 0)
+	var snaps []*Snapshot
+	vs := New()
+	for i := 0; i < 20; i++ {
+		vs.Set("a", i)
+		if i%3 == 0 {
+			vs.Set("b", 1<<60+i)
+		}
+		s := vs.Snap()
+		s.When = base.Add(time.Duration(i) * time.Millisecond)
+		snaps = append(snaps, s)
+	}
+	snaps = Trim(snaps)
+	name := filepath.Join(t.TempDir(), "snaps")
+	var b bytes.Buffer
+	sw, err := NewSnapshotWriter(&b)
+	if err != nil {
+		t.Fatalf("NewSnapshotWriter failed: %v", err)
+	}
+	for _, s := range snaps {
+		if err := sw.Write(s); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := sw.Write(snaps[0]); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("out of order write: got err=%v, want=%v", err, ErrUnsorted)
+	}
+	if err := os.WriteFile(name, b.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write %q: %v", name, err)
+	}
+	sr, err := OpenSnapshotReader(name)
+	if err != nil {
+		t.Fatalf("OpenSnapshotReader failed: %v", err)
+	}
+	defer sr.Close()
+	if got, want := sr.Len(), len(snaps); got != want {
+		t.Fatalf("got %d snapshots, want %d", got, want)
+	}
+	at := base.Add(10 * time.Millisecond)
+	i, v, err := InferFile(sr, at, "b")
+	if err != nil || i != 9 || v != int64(1<<60+9) {
+		t.Errorf("InferFile: got=%d, %v, %v", i, v, err)
+	}
+	from, to := base.Add(3*time.Millisecond), base.Add(15*time.Millisecond)
+	want, err := ExtractNumbers(snaps, time.Millisecond, from, to, []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("ExtractNumbers failed: %v", err)
+	}
+	got, err := ExtractNumbersFile(sr, time.Millisecond, from, to, []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("ExtractNumbersFile failed: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+	n := 0
+	if err := sr.Range(from, to, func(s *Snapshot) error {
+		n++
+		return nil
+	}); err != nil || n != 12 {
+		t.Errorf("Range: got %d snapshots, %v, want 12", n, err)
+	}
+	data := b.Bytes()
+	for _, cut := range []int{1, snapRecordHead + 3} {
+		short := data[:len(data)-cut]
+		tr, err := NewSnapshotReader(bytes.NewReader(short), int64(len(short)))
+		if err != nil {
+			t.Fatalf("truncated by %d: %v", cut, err)
+		}
+		if got, want := tr.Len(), len(snaps)-1; got != want {
+			t.Errorf("truncated by %d: got %d snapshots, want %d", cut, got, want)
+		}
+		if _, err := ExtractNumbersFile(tr, time.Millisecond, from, to, []string{"a", "b"}); err != nil {
+			t.Errorf("truncated by %d: ExtractNumbersFile failed: %v", cut, err)
+		}
+	}
+	data[len(snapMagic)+3]++
+	if _, err := NewSnapshotReader(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrVersion) {
+		t.Errorf("got err=%v, want=%v", err, ErrVersion)
+	}
+}
+
+func TestCheckSnapVersion(t *testing.T) {
+	if err := checkSnapVersion(snapVersion); err != nil {
+		t.Errorf("current version rejected: %v", err)
+	}
+	for _, v := range []uint32{0, snapVersion + 1} {
+		if err := checkSnapVersion(v); !errors.Is(err, ErrVersion) {
+			t.Errorf("version %d: got=%v, want=%v", v, err, ErrVersion)
+		}
+	}
+}
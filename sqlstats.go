@@ -0,0 +1,60 @@
+package vars
+
+import (
+	"database/sql"
+)
+
+// SQLCollector is a Collector of the connection pool statistics of a
+// database/sql database, as reported by its Stats method. For a
+// prefix of "db", it sets these metrics:
+//
+//	db.max_open_connections  the limit on open connections
+//	db.open_connections      the established connections
+//	db.in_use                the connections in use
+//	db.idle                  the idle connections
+//	db.wait_count            the connections waited for
+//	db.wait_duration         the total time waited, a time.Duration
+//	db.max_idle_closed       the connections closed by SetMaxIdleConns
+//	db.max_idle_time_closed  the connections closed by SetConnMaxIdleTime
+//	db.max_lifetime_closed   the connections closed by SetConnMaxLifetime
+//
+// The cumulative ones have a Kind of KindCounter, and the others
+// KindGauge.
+type SQLCollector struct {
+	db     *sql.DB
+	prefix string
+}
+
+// NewSQLCollector returns a SQLCollector for db, setting metrics
+// under prefix. To keep the metrics current, add it to a Metrics with
+// AddCollector, and collect that with CollectEvery.
+func NewSQLCollector(db *sql.DB, prefix string) *SQLCollector {
+	return &SQLCollector{db: db, prefix: prefix}
+}
+
+// Collect sets the statistics of the database in m.
+func (c *SQLCollector) Collect(m *Metrics) error {
+	st := c.db.Stats()
+	vs := []struct {
+		k    string
+		v    interface{}
+		kind Kind
+	}{
+		{"max_open_connections", st.MaxOpenConnections, KindGauge},
+		{"open_connections", st.OpenConnections, KindGauge},
+		{"in_use", st.InUse, KindGauge},
+		{"idle", st.Idle, KindGauge},
+		{"wait_count", st.WaitCount, KindCounter},
+		{"wait_duration", st.WaitDuration, KindCounter},
+		{"max_idle_closed", st.MaxIdleClosed, KindCounter},
+		{"max_idle_time_closed", st.MaxIdleTimeClosed, KindCounter},
+		{"max_lifetime_closed", st.MaxLifetimeClosed, KindCounter},
+	}
+	for _, v := range vs {
+		k := c.prefix + "." + v.k
+		if err := m.setCollected(k, v.v, Meta{Kind: v.kind}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
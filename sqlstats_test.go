@@ -0,0 +1,41 @@
+package vars
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// nullDriver is a database/sql driver that cannot connect.
+type nullDriver struct{}
+
+func (nullDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("no database")
+}
+
+func init() {
+	sql.Register("vars-test-null", nullDriver{})
+}
+
+func TestSQLCollector(t *testing.T) {
+	db, err := sql.Open("vars-test-null", "")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+	m := New()
+	m.AddCollector(NewSQLCollector(db, "db"))
+	s := m.Snap()
+	if got := s.Get("db.max_open_connections"); got != 7 {
+		t.Errorf("max_open_connections: got=%v, want=7", got)
+	}
+	if got := s.Get("db.wait_duration"); got != time.Duration(0) {
+		t.Errorf("wait_duration: got=%#v, want=0", got)
+	}
+	if meta, _ := s.Values.GetMeta("db.wait_count"); meta.Kind != KindCounter {
+		t.Errorf("wait_count kind: got=%q", meta.Kind)
+	}
+}
@@ -0,0 +1,253 @@
+package vars
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQuantiles are the quantiles reported by a Summary created
+// without explicitly chosen ones.
+var DefaultQuantiles = []float64{0.5, 0.95, 0.99}
+
+// The accuracy and memory use of a Summary are governed by these
+// parameters: the digest holds at most about summaryCompression
+// centroids, and observations are merged into it in batches of
+// summaryBatch.
+const (
+	summaryCompression = 100
+	summaryBatch       = 500
+)
+
+// centroid is a cluster of observations of a Summary, represented by
+// their mean and number.
+type centroid struct {
+	mean, weight float64
+}
+
+// Summary estimates quantiles of the observed values, without the
+// need to choose bucket boundaries in advance. It uses a merging
+// t-digest, which bounds its memory use and is most accurate for the
+// extreme quantiles. It is safe for concurrent use.
+//
+// When a Metrics holding a Summary is snapshotted, the Summary is
+// replaced by derived numerical metrics: for a Summary held by metric
+// k, k.count and k.sum hold the number and sum of the observed
+// values, and, for example, k.p99 holds the estimate of the 0.99
+// quantile.
+type Summary struct {
+	mu        sync.Mutex
+	quantiles []float64
+	centroids []centroid
+	// batch holds the observations not yet merged into centroids.
+	batch    []float64
+	count    uint64
+	sum      float64
+	min, max float64
+	// last is the time of the most recent observation.
+	last time.Time
+}
+
+// NewSummary returns a Summary that reports the given quantiles,
+// each 0 <= q <= 1, or DefaultQuantiles if none are given.
+func NewSummary(quantiles ...float64) *Summary {
+	if len(quantiles) == 0 {
+		quantiles = DefaultQuantiles
+	}
+	q := append([]float64(nil), quantiles...)
+	sort.Float64s(q)
+	return &Summary{quantiles: q}
+}
+
+// Observe records the value v in the summary. NaN values are ignored.
+func (s *Summary) Observe(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	s.last = time.Now()
+	s.batch = append(s.batch, v)
+	if len(s.batch) >= summaryBatch {
+		s.merge()
+	}
+}
+
+// merge merges the batched observations into the centroids. The
+// caller must hold s.mu.
+func (s *Summary) merge() {
+	if len(s.batch) == 0 {
+		return
+	}
+	all := make([]centroid, 0, len(s.centroids)+len(s.batch))
+	all = append(all, s.centroids...)
+	for _, v := range s.batch {
+		all = append(all, centroid{mean: v, weight: 1})
+	}
+	s.batch = s.batch[:0]
+	sort.Slice(all, func(a, b int) bool {
+		return all[a].mean < all[b].mean
+	})
+	total := float64(s.count)
+	out := all[:1]
+	before := 0.0
+	for _, c := range all[1:] {
+		last := &out[len(out)-1]
+		w := last.weight + c.weight
+		if summaryScale((before+w)/total)-summaryScale(before/total) <= 1 {
+			last.mean += (c.mean - last.mean) * c.weight / w
+			last.weight = w
+			continue
+		}
+		before += last.weight
+		out = append(out, c)
+	}
+	s.centroids = append([]centroid(nil), out...)
+}
+
+// summaryScale is the t-digest scale function that limits the
+// centroids of a Summary to span at most one unit of it each, so they
+// are small near the extreme quantiles.
+func summaryScale(q float64) float64 {
+	return summaryCompression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// lastActive returns the time of the most recent observation.
+func (s *Summary) lastActive() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// Count returns the number of observed values.
+func (s *Summary) Count() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Sum returns the sum of the observed values.
+func (s *Summary) Sum() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sum
+}
+
+// Quantile estimates the q-th quantile, 0 <= q <= 1, of the observed
+// values. Quantile(0) and Quantile(1) are the exact minimum and
+// maximum. With no observations, the result is NaN.
+func (s *Summary) Quantile(q float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quantile(q)
+}
+
+// quantile implements Quantile. The caller must hold s.mu.
+func (s *Summary) quantile(q float64) float64 {
+	switch {
+	case s.count == 0 || math.IsNaN(q):
+		return math.NaN()
+	case q < 0:
+		return math.Inf(-1)
+	case q > 1:
+		return math.Inf(1)
+	}
+	s.merge()
+	total := float64(s.count)
+	rank := q * total
+	// Interpolate between the centers of the centroids, and between
+	// the extreme centroids and the exact minimum and maximum.
+	prev, prevCenter := s.min, 0.0
+	cum := 0.0
+	for _, c := range s.centroids {
+		center := cum + c.weight/2
+		if rank < center {
+			return prev + (c.mean-prev)*(rank-prevCenter)/(center-prevCenter)
+		}
+		prev, prevCenter = c.mean, center
+		cum += c.weight
+	}
+	if total == prevCenter {
+		return s.max
+	}
+	return prev + (s.max-prev)*(rank-prevCenter)/(total-prevCenter)
+}
+
+// quantileSuffix returns the suffix of the key of the derived metric
+// holding quantile q, for example, ".p99" for 0.99 and ".p99_9" for
+// 0.999.
+func quantileSuffix(q float64) string {
+	return ".p" + strings.Replace(strconv.FormatFloat(q*100, 'f', -1, 64), ".", "_", 1)
+}
+
+// derived returns the derived metrics of the summary, keyed by their
+// suffix.
+func (s *Summary) derived() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := map[string]interface{}{
+		".count": s.count,
+		".sum":   s.sum,
+	}
+	for _, q := range s.quantiles {
+		d[quantileSuffix(q)] = s.quantile(q)
+	}
+	return d
+}
+
+// String summarizes the summary for text outputs.
+func (s *Summary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := []byte(fmt.Sprintf("count=%d sum=%g", s.count, s.sum))
+	for _, q := range s.quantiles {
+		b = append(b, ' ')
+		b = append(b, quantileSuffix(q)[1:]...)
+		b = append(b, '=')
+		b = strconv.AppendFloat(b, s.quantile(q), 'g', -1, 64)
+	}
+	return string(b)
+}
+
+// MarshalJSON encodes the summary as a JSON object holding its count,
+// sum and quantile estimates, named as for the derived metrics.
+// Non-finite values, such as the estimates when there are no
+// observations, are encoded as null.
+func (s *Summary) MarshalJSON() ([]byte, error) {
+	return marshalDerived(s.derived())
+}
+
+// Summary returns the summary held by metric k of m, creating it to
+// report the given quantiles, or DefaultQuantiles if none are given,
+// if k does not hold a *Summary. The Kind of k is set to
+// KindSummary. If creating k would exceed the limit set with
+// SetMaxKeys, and new metrics are rejected, the returned Summary is
+// not held by m.
+func (m *Metrics) Summary(k string, quantiles ...float64) *Summary {
+	if m == nil {
+		return NewSummary(quantiles...)
+	}
+	m.mu.Lock()
+	s, ok := m.Detail[k].(*Summary)
+	if !ok {
+		s = NewSummary(quantiles...)
+		ok = m.set(k, s) == nil
+	}
+	if ok {
+		m.applyKind(k, KindSummary)
+	}
+	m.unlock()
+	return s
+}
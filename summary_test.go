@@ -0,0 +1,68 @@
+package vars
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSummaryQuantile(t *testing.T) {
+	s := NewSummary()
+	if got := s.Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("empty summary: got=%g, want=NaN", got)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(10000) {
+		s.Observe(float64(i + 1))
+	}
+	vs := []struct {
+		q, want, tolerance float64
+	}{
+		{q: 0, want: 1},
+		{q: 0.5, want: 5000, tolerance: 50},
+		{q: 0.95, want: 9500, tolerance: 20},
+		{q: 0.99, want: 9900, tolerance: 5},
+		{q: 1, want: 10000},
+	}
+	for i, v := range vs {
+		if got := s.Quantile(v.q); math.Abs(got-v.want) > v.tolerance {
+			t.Errorf("[%d] Quantile(%g): got=%g, want=%g±%g", i, v.q, got, v.want, v.tolerance)
+		}
+	}
+	if n := len(s.centroids); n > summaryCompression {
+		t.Errorf("digest holds %d centroids", n)
+	}
+}
+
+func TestMetricsSummary(t *testing.T) {
+	m := New()
+	s := m.Summary("latency", 0.5, 0.999)
+	if m.Summary("latency") != s {
+		t.Error("second Summary call returned a new summary")
+	}
+	for i := 1; i <= 3; i++ {
+		s.Observe(float64(i))
+	}
+	snap := m.Snap()
+	want := map[string]interface{}{
+		"latency.count": uint64(3),
+		"latency.sum":   6.0,
+		"latency.p50":   2.0,
+		"latency.p99_9": 3.0,
+	}
+	if len(snap.Values.Detail) != len(want) {
+		t.Errorf("snapshot: got=%v, want=%v", snap.Values.Detail, want)
+	}
+	for k, v := range want {
+		if got := snap.Values.Get(k); got != v {
+			t.Errorf("snapshot %q: got=%#v, want=%#v", k, got, v)
+		}
+	}
+	if got, want := m.String(), `{"latency":{"count":3,"p50":2,"p99_9":3,"sum":6}}`; got != want {
+		t.Errorf("json: got=%s, want=%s", got, want)
+	}
+	if got := string(m.DumpMDTable()); !strings.Contains(got, "\nlatency.p50 | 2\n") {
+		t.Errorf("markdown lacks latency.p50:\n%s", got)
+	}
+}
@@ -0,0 +1,70 @@
+package vars
+
+import (
+	"time"
+)
+
+// Timer measures how long a section of code takes. Each measurement
+// updates three metrics of its Metrics: for a Timer of metric k,
+// k.count is the number of measurements, k.seconds is their
+// cumulative duration in seconds, and k.rate is the rate of
+// measurements per second over a sliding window of the most recent
+// ones, as computed by a RateMeter. The rate is computed each time
+// k.rate is read, see SetFunc, so it decays once measurements stop.
+type Timer struct {
+	m     *Metrics
+	k     string
+	calls *RateMeter
+
+	// now is the clock used to time measurements.
+	now func() time.Time
+}
+
+// TimerRun is a measurement in progress, returned by Timer.Start.
+type TimerRun struct {
+	t     *Timer
+	start time.Time
+}
+
+// NewTimer returns a Timer that records its measurements in the
+// metrics of m derived from k. The rate is computed over the most
+// recent window measurements, as for NewRateMeter.
+func NewTimer(m *Metrics, k string, window int) *Timer {
+	m.setKind(k+".count", KindCounter)
+	m.setKind(k+".seconds", KindCounter)
+	m.setKind(k+".rate", KindGauge)
+	t := &Timer{
+		m:     m,
+		k:     k,
+		calls: NewRateMeter(m, k+".count", window),
+		now:   time.Now,
+	}
+	m.SetFunc(k+".rate", func() interface{} { return t.calls.PerSecond() })
+	return t
+}
+
+// Record records a measurement of duration d.
+func (t *Timer) Record(d time.Duration) {
+	t.m.Add(t.k+".seconds", d.Seconds())
+	t.calls.Add(1)
+}
+
+// Start starts a measurement, which is recorded when the returned
+// TimerRun is stopped.
+func (t *Timer) Start() TimerRun {
+	return TimerRun{t: t, start: t.now()}
+}
+
+// Stop records the duration of the measurement since it was started,
+// and returns it.
+func (r TimerRun) Stop() time.Duration {
+	d := r.t.now().Sub(r.start)
+	r.t.Record(d)
+	return d
+}
+
+// Time calls fn and records how long it took.
+func (t *Timer) Time(fn func()) {
+	defer t.Start().Stop()
+	fn()
+}
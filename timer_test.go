@@ -0,0 +1,38 @@
+package vars
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	m := New()
+	tm := NewTimer(m, "query", 10)
+	now := time.Now()
+	tm.now = func() time.Time { return now }
+	tm.calls.now = tm.now
+	for _, d := range []time.Duration{time.Second, 500 * time.Millisecond, 1500 * time.Millisecond} {
+		tm.Time(func() { now = now.Add(d) })
+	}
+	if got := tm.Start().Stop(); got != 0 {
+		t.Errorf("Stop: got=%v, want=0", got)
+	}
+	vs := map[string]float64{
+		"query.count":   4,
+		"query.seconds": 3,
+		"query.rate":    20.0 / 17,
+	}
+	for k, want := range vs {
+		if got, err := m.GetNumber(k); err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: got=%g, %v, want=%g", k, got, err, want)
+		}
+	}
+	now = now.Add(3 * time.Second)
+	if got, err := m.GetNumber("query.rate"); err != nil || math.Abs(got-21.0/38) > 1e-9 {
+		t.Errorf("idle rate: got=%g, %v, want=%g", got, err, 21.0/38)
+	}
+	if meta, _ := m.GetMeta("query.count"); meta.Kind != KindCounter {
+		t.Errorf("count kind: got=%q", meta.Kind)
+	}
+}
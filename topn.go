@@ -0,0 +1,81 @@
+package vars
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// KeyNumber holds the name of a numerical metric and its value.
+type KeyNumber struct {
+	Key   string
+	Value float64
+}
+
+// before orders the results of TopN: higher values first, and equal
+// values in key order.
+func (a KeyNumber) before(b KeyNumber) bool {
+	if a.Value != b.Value {
+		return a.Value > b.Value
+	}
+	return a.Key < b.Key
+}
+
+// topHeap is a heap of at most n KeyNumbers, with the last of them to
+// be returned by TopN at its root.
+type topHeap []KeyNumber
+
+func (h topHeap) Len() int            { return len(h) }
+func (h topHeap) Less(i, j int) bool  { return h[j].before(h[i]) }
+func (h topHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x interface{}) { *h = append(*h, x.(KeyNumber)) }
+
+func (h *topHeap) Pop() interface{} {
+	x := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return x
+}
+
+// TopN returns the n numerical metrics with the highest values, as
+// converted by AsNumber, highest first. Metrics with equal values are
+// returned in key order. Non-numerical metrics, and NaN values, are
+// excluded. Only n of the metrics are held while they are selected,
+// so this is efficient for a small n and a large number of metrics.
+func (m *Metrics) TopN(n int) []KeyNumber {
+	if m == nil || n <= 0 {
+		return nil
+	}
+	m.mu.RLock()
+	h := make(topHeap, 0, min(n, len(m.Detail)))
+	var funcs map[string]interface{}
+	consider := func(k string, v interface{}) {
+		x, err := AsNumber(v)
+		if err != nil || math.IsNaN(x) {
+			return
+		}
+		kn := KeyNumber{Key: k, Value: x}
+		if len(h) < n {
+			heap.Push(&h, kn)
+		} else if kn.before(h[0]) {
+			h[0] = kn
+			heap.Fix(&h, 0)
+		}
+	}
+	for k, v := range m.Detail {
+		if _, ok := v.(computed); ok {
+			if funcs == nil {
+				funcs = make(map[string]interface{})
+			}
+			funcs[k] = v
+			continue
+		}
+		consider(k, v)
+	}
+	m.mu.RUnlock()
+	resolveFuncs(funcs)
+	for k, v := range funcs {
+		consider(k, v)
+	}
+	sort.Slice(h, func(i, j int) bool { return h[i].before(h[j]) })
+	return h
+}
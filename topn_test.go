@@ -0,0 +1,27 @@
+package vars
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestTopN(t *testing.T) {
+	m := New()
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("k%03d", i), i%10)
+	}
+	m.Set("nan", math.NaN())
+	m.Set("s", "x")
+	m.Set("big", 1e6)
+	got := fmt.Sprint(m.TopN(4))
+	if want := "[{big 1e+06} {k009 9} {k019 9} {k029 9}]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	if got := len(m.TopN(math.MaxInt)); got != 101 {
+		t.Errorf("got %d metrics, want 101", got)
+	}
+	if got := m.TopN(0); got != nil {
+		t.Errorf("got=%v, want nil", got)
+	}
+}
@@ -1,10 +1,19 @@
 // Package vars maintains a list of metrics. These can be used to
 // monitor behavior of an application.
+//
+// A metric may be set to nil. Such a metric is present, but it has no
+// value: text outputs render it as empty, JSON outputs as null, and
+// numerical outputs omit it.
 package vars
 
 import (
+	"container/list"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"sort"
 	"strings"
 	"sync"
@@ -13,173 +22,1414 @@ import (
 
 // Metrics holds a set of metric values that can be updated
 // atomically.
+//
+// No function provided by the caller, such as a formatter, a
+// predicate or a callback, nor any method of a metric value, such as
+// String or MarshalJSON, is ever called while the internal lock of a
+// Metrics is held. Such functions may therefore safely use the
+// methods of the Metrics they are called for.
 type Metrics struct {
-	mu     sync.Mutex
+	mu sync.RWMutex
+	// Detail holds the metric values. The value of a metric
+	// updated through a Counter or Gauge handle is a cell of the
+	// handle, which is formatted, and encoded as JSON, as its
+	// current float64 value. Get and the outputs of m report the
+	// float64 value itself.
 	Detail map[string]interface{}
+
+	// formats holds the display formatters of specific metrics.
+	formats map[string]func(interface{}) string
+	// meta holds the descriptive information of specific metrics.
+	meta map[string]Meta
+	// collected holds the metrics set by the collectors of this
+	// package, see setCollected.
+	collected map[string]bool
+	// wake, when not nil, is closed to wake those waiting for a
+	// metric value to change.
+	wake chan struct{}
+	// touched holds the time each metric was last set, as an
+	// element of recency, which orders them from the least to the
+	// most recently set.
+	touched map[string]*list.Element
+	recency *list.List
+	// maxKeys, when positive, limits the number of distinct
+	// metrics, and overflow selects how the limit is enforced.
+	maxKeys  int
+	overflow Overflow
+	// growth holds the callbacks registered with OnGrowthPast,
+	// and pending the calls of them to be made once m.mu is
+	// released.
+	growth  []growthHook
+	pending []func()
+	// collectors holds the collectors registered with
+	// AddCollector.
+	collectors []Collector
+}
+
+// New establishes a group of metrics.
+func New() *Metrics {
+	return &Metrics{Detail: make(map[string]interface{})}
+}
+
+// ErrInvalid, ErrNotNumber etc are standard errors returned by this
+// package.
+var (
+	ErrInvalid     = errors.New("undefined metrics")
+	ErrNotNumber   = errors.New("not a number")
+	ErrNotFound    = errors.New("not found")
+	ErrNonFinite   = errors.New("non-finite number")
+	ErrBadStep     = errors.New("invalid time step")
+	ErrNotDuration = errors.New("not a duration")
+	ErrConflict    = errors.New("conflicting registration")
+	ErrAlreadySet  = errors.New("already set")
+	ErrTooManyKeys = errors.New("too many metrics")
+	ErrVersion     = errors.New("unsupported format version")
+	ErrNotTime     = errors.New("not a time")
+	ErrUnsorted    = errors.New("snapshots out of time order")
+	ErrOutOfRange  = errors.New("out of range")
+)
+
+// set sets the value of metric k to v and wakes any waiters. Every
+// change made to a metric value by this package is made via set. It
+// returns ErrTooManyKeys if creating k would exceed the limit set with
+// SetMaxKeys. A float64 v is stored in place when k is updated
+// through a handle, see Counter. The caller must hold m.mu.
+func (m *Metrics) set(k string, v interface{}) error {
+	switch old := m.Detail[k].(type) {
+	case *cell:
+		if v == old {
+			break
+		}
+		if x, isFloat := v.(float64); isFloat {
+			old.store(x)
+			v = old
+		} else {
+			release(old)
+		}
+	case detachable:
+		if v != old {
+			release(old)
+		}
+	}
+	if err := m.admit(k); err != nil {
+		return err
+	}
+	before := len(m.Detail)
+	m.Detail[k] = v
+	m.grew(before)
+	m.touch(k, time.Now())
+	m.notify()
+	return nil
+}
+
+// unlock releases m.mu, held for writing, and then makes any calls
+// of callbacks triggered while it was held. Every write lock of m.mu
+// is released via unlock.
+func (m *Metrics) unlock() {
+	fns := m.pending
+	m.pending = nil
+	m.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// notify wakes any waiters for a metric value change. The caller must
+// hold m.mu.
+func (m *Metrics) notify() {
+	if m.wake != nil {
+		close(m.wake)
+		m.wake = nil
+	}
+}
+
+// LastUpdated returns the time metric k was last set via the methods
+// of m, or observed in, for a value updated in place such as a
+// *Histogram, and whether that is known.
+func (m *Metrics) LastUpdated(k string) (time.Time, bool) {
+	if m == nil {
+		return time.Time{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.updated(k)
+}
+
+// activeValue is implemented by metric values, such as *Histogram,
+// that are updated in place rather than via the methods of the
+// Metrics holding them. lastActive returns the time of the most
+// recent update.
+type activeValue interface {
+	lastActive() time.Time
+}
+
+// updated implements LastUpdated. The caller must hold m.mu.
+func (m *Metrics) updated(k string) (time.Time, bool) {
+	t, ok := m.touchedAt(k)
+	if a, isActive := m.Detail[k].(activeValue); ok && isActive {
+		if u := a.lastActive(); u.After(t) {
+			t = u
+		}
+	}
+	return t, ok
+}
+
+// Prune deletes the metrics that have not been updated, as reported
+// by LastUpdated, for at least olderThan. Metrics with no known
+// LastUpdated time, for example, ones added directly to Detail, are
+// never pruned.
+func (m *Metrics) Prune(olderThan time.Duration) {
+	if m == nil {
+		return
+	}
+	cutoff := time.Now().Add(-olderThan)
+	m.mu.Lock()
+	defer m.unlock()
+	pruned := false
+	for m.recency != nil && m.recency.Len() != 0 {
+		tc := m.recency.Front().Value.(*touch)
+		if tc.t.After(cutoff) {
+			break
+		}
+		if t, _ := m.updated(tc.k); t.After(cutoff) {
+			tc.t = t
+			m.reorder(m.recency.Front())
+			continue
+		}
+		m.drop(tc.k)
+		pruned = true
+	}
+	if pruned {
+		m.notify()
+	}
+}
+
+// Delete removes metric k, along with its formatter and descriptive
+// information, and reports whether it was present. Handles obtained
+// for k, such as a *Counter, remain usable, and recreate it when next
+// updated, but a *Histogram, *Summary or *Meter held by k is no
+// longer part of m.
+func (m *Metrics) Delete(k string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	_, ok := m.Detail[k]
+	m.drop(k)
+	if ok {
+		m.notify()
+	}
+	return ok
+}
+
+// Reset sets the numerical metric k back to zero, preserving its
+// numerical type as for DrainInto. It fails with ErrNotFound if k is
+// absent, and with ErrNotNumber, leaving it unchanged, if k is not
+// numerical.
+func (m *Metrics) Reset(k string) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	v, ok := m.Detail[k]
+	if !ok {
+		return fmt.Errorf("metric %q: %w", k, ErrNotFound)
+	}
+	z, ok := zeroLike(v)
+	if !ok {
+		return fmt.Errorf("metric %q: %w", k, ErrNotNumber)
+	}
+	return m.set(k, z)
+}
+
+// Clear removes all of the metrics of m, along with their formatters
+// and descriptive information. The collectors and callbacks
+// registered with m, and its limit on the number of metrics, are
+// unaffected.
+func (m *Metrics) Clear() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	for _, v := range m.Detail {
+		release(v)
+	}
+	m.Detail = make(map[string]interface{})
+	m.touched, m.recency, m.formats, m.meta, m.collected = nil, nil, nil, nil, nil
+	m.notify()
+}
+
+// WaitForValue waits until pred returns true for the value of metric
+// k, or ctx is done, in which case it returns ctx.Err(). The value is
+// reevaluated after each change made to any metric via the methods
+// of m. This is intended to let tests of asynchronous code wait for a
+// metric without polling.
+func (m *Metrics) WaitForValue(ctx context.Context, k string, pred func(interface{}) bool) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	for {
+		m.mu.Lock()
+		v := m.Detail[k]
+		if m.wake == nil {
+			m.wake = make(chan struct{})
+		}
+		wake := m.wake
+		m.unlock()
+		if pred(resolve(v)) {
+			return nil
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Set sets the value of a specific metric.
+func (m *Metrics) Set(k string, value interface{}) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	return m.set(k, value)
+}
+
+// SetRounded sets metric k to v rounded to the given number of
+// decimal places. A negative number of decimals rounds to tens,
+// hundreds and so on. Rounding removes the noise in the least
+// significant digits of computed values, such as ratios, so
+// successive values that are equal for practical purposes are
+// recognized as unchanged by Trim.
+func (m *Metrics) SetRounded(k string, v float64, decimals int) error {
+	p := math.Pow(10, float64(decimals))
+	if r := math.Round(v*p) / p; !math.IsInf(r, 0) && !math.IsNaN(r) {
+		v = r
+	}
+	return m.Set(k, v)
+}
+
+// SetOnce sets the value of a metric that has not yet been set. It
+// fails with ErrAlreadySet, leaving the value unchanged, if the metric
+// already exists. It is intended for metrics that record immutable
+// facts, such as a build version, where a second setting is a bug.
+func (m *Metrics) SetOnce(k string, value interface{}) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if _, ok := m.Detail[k]; ok {
+		return ErrAlreadySet
+	}
+	return m.set(k, value)
+}
+
+// Replace atomically replaces all of the metric values with a copy
+// of detail. Readers see either the complete old set of metrics or
+// the complete new one, never a mix of the two. The limit set with
+// SetMaxKeys is not applied to the replacement set.
+func (m *Metrics) Replace(detail map[string]interface{}) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	d := make(map[string]interface{}, len(detail))
+	for k, v := range detail {
+		d[k] = v
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.unlock()
+	before := len(m.Detail)
+	for _, v := range m.Detail {
+		release(v)
+	}
+	m.Detail, m.touched, m.recency, m.collected = d, nil, nil, nil
+	for k := range d {
+		m.touch(k, now)
+	}
+	m.grew(before)
+	m.notify()
+	return nil
+}
+
+// CopyTo sets each metric of dst to the value of the same metric of
+// m, leaving the other metrics of dst unchanged. Values are copied
+// exactly, keeping their concrete types, so, for example, an int32
+// metric of m is an int32 metric of dst. Histograms are copied as for
+// Snap. It fails with ErrTooManyKeys if dst cannot hold all of the
+// metrics, see SetMaxKeys.
+func (m *Metrics) CopyTo(dst *Metrics) error {
+	if m == nil || dst == nil {
+		return ErrInvalid
+	}
+	s := m.Snap()
+	dst.mu.Lock()
+	defer dst.unlock()
+	for k, v := range s.Values.Detail {
+		if err := dst.set(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TimedValue is a metric value that carries the time it was measured,
+// which can be different from the time of any snapshot holding it.
+type TimedValue struct {
+	When time.Time
+	V    interface{}
+}
+
+// SetTimestamped sets the value of a specific metric, recording when
+// as the time the value was measured.
+func (m *Metrics) SetTimestamped(k string, value interface{}, when time.Time) error {
+	return m.Set(k, TimedValue{When: when, V: value})
+}
+
+// Get returns the current value of a specific metric.
+func (m *Metrics) Get(k string) interface{} {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	v := m.Detail[k]
+	m.mu.RUnlock()
+	return resolve(v)
+}
+
+// values returns a copy of the current metric values.
+func (m *Metrics) values() map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	d := make(map[string]interface{}, len(m.Detail))
+	for k, v := range m.Detail {
+		d[k] = v
+	}
+	m.mu.RUnlock()
+	resolveFuncs(d)
+	return d
+}
+
+// SetFormatter registers a function, f, to render the value of
+// metric k in the text outputs of this package, for example,
+// DumpMDTable. The formatter only affects how the value is displayed,
+// the stored value is unchanged. A nil f removes any formatter for
+// k.
+func (m *Metrics) SetFormatter(k string, f func(interface{}) string) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if f == nil {
+		delete(m.formats, k)
+		return nil
+	}
+	if m.formats == nil {
+		m.formats = make(map[string]func(interface{}) string)
+	}
+	m.formats[k] = f
+	return nil
+}
+
+// AsNumber returns a numerical value for an interface{} value, or an
+// error. A TimedValue is a number if its V value is. A time.Duration
+// is a number of nanoseconds. Since a float64 holds integers exactly
+// only up to 2^53, larger integer values lose precision through this
+// conversion; AsInt64 avoids this for integer values.
+func AsNumber(v interface{}) (float64, error) {
+	switch v.(type) {
+	case TimedValue:
+		return AsNumber(v.(TimedValue).V)
+	case time.Duration:
+		return float64(v.(time.Duration)), nil
+	case float32:
+		return float64(v.(float32)), nil
+	case int:
+		return float64(v.(int)), nil
+	case int32:
+		return float64(v.(int32)), nil
+	case int64:
+		return float64(v.(int64)), nil
+	case uint:
+		return float64(v.(uint)), nil
+	case uint32:
+		return float64(v.(uint32)), nil
+	case uint64:
+		return float64(v.(uint64)), nil
+	case float64:
+		return v.(float64), nil
+	case *cell:
+		return v.(*cell).load(), nil
+	default:
+		return 0, ErrNotNumber
+	}
+}
+
+// AsInt64 returns the exact value of an integer metric value. It
+// returns false for a value that is not an integer, including any
+// float64 value, or that cannot be represented as an int64.
+func AsInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case TimedValue:
+		return AsInt64(x.V)
+	case time.Duration:
+		return int64(x), true
+	case int:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint:
+		if uint64(x) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		if x > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(x), true
+	default:
+		return 0, false
+	}
+}
+
+// zeroLike returns a zero value of the same numerical type as v. If
+// v is not numerical, it returns false.
+func zeroLike(v interface{}) (interface{}, bool) {
+	switch v.(type) {
+	case int:
+		return int(0), true
+	case int32:
+		return int32(0), true
+	case int64:
+		return int64(0), true
+	case uint:
+		return uint(0), true
+	case uint32:
+		return uint32(0), true
+	case uint64:
+		return uint64(0), true
+	case float64, *cell:
+		return float64(0), true
+	case float32:
+		return float32(0), true
+	case time.Duration:
+		return time.Duration(0), true
+	case TimedValue:
+		tv := v.(TimedValue)
+		z, ok := zeroLike(tv.V)
+		return TimedValue{When: tv.When, V: z}, ok
+	default:
+		return v, false
+	}
+}
+
+// GetNumber returns the numerical value of a metric or, in the case
+// the metric is not a number, it indicates this with an error value.
+func (m *Metrics) GetNumber(k string) (float64, error) {
+	if m == nil {
+		return 0, ErrNotNumber
+	}
+	return AsNumber(m.Get(k))
+}
+
+// MustGetNumber is the same as GetNumber, but it panics if metric k
+// is absent or not numerical. As for regexp.MustCompile, it is
+// intended for code, such as initialization and tests, where the
+// absence of the metric is a programming error.
+func (m *Metrics) MustGetNumber(k string) float64 {
+	n, state := m.GetNumberState(k)
+	switch state {
+	case NumMissing:
+		panic(fmt.Sprintf("vars: metric %q: %v", k, ErrNotFound))
+	case NumNotNumber:
+		panic(fmt.Sprintf("vars: metric %q: %v", k, ErrNotNumber))
+	}
+	return n
+}
+
+// NumState indicates the outcome of GetNumberState.
+type NumState int
+
+const (
+	// NumOK indicates the metric holds a numerical value.
+	NumOK NumState = iota
+	// NumNotNumber indicates the metric exists, but its value is
+	// not numerical.
+	NumNotNumber
+	// NumMissing indicates the metric does not exist.
+	NumMissing
+)
+
+// GetNumberState is the same as GetNumber, but it distinguishes a
+// metric that is absent from one that holds a non-numerical value.
+// The returned number is only meaningful when the state is NumOK.
+func (m *Metrics) GetNumberState(k string) (float64, NumState) {
+	if m == nil {
+		return 0, NumMissing
+	}
+	m.mu.RLock()
+	v, ok := m.Detail[k]
+	m.mu.RUnlock()
+	if !ok {
+		return 0, NumMissing
+	}
+	n, err := AsNumber(resolve(v))
+	if err != nil {
+		return 0, NumNotNumber
+	}
+	return n, NumOK
+}
+
+// Peek returns the value of metric k along with its numerical form,
+// if it has one, as read together in a single step.
+func (m *Metrics) Peek(k string) (v interface{}, num float64, isNum bool) {
+	v = m.Get(k)
+	num, err := AsNumber(v)
+	return v, num, err == nil
+}
+
+// Add adds a number to a metric or, in the case the metric was not
+// previously numerical, it replaces the metric with the provided
+// number, n. If adding a new metric would exceed the limit set with
+// SetMaxKeys, and new metrics are rejected, the number is dropped.
+//
+// The Add variants preserve the numerical type of the metric: the
+// result of adding to an integer metric, including a time.Duration,
+// remains of that type provided n is a whole number and the result
+// fits the type, otherwise it becomes a float64. Adding to a float32
+// metric yields a float32, and adding to a float64 metric yields a
+// float64. A metric that was absent or not numerical becomes a
+// float64, or a float32 for AddFloat32. Adding to a TimedValue
+// replaces it with a plain value of the type of its V value.
+func (m *Metrics) Add(k string, n float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	m.add(k, n, math.Inf(-1), math.Inf(1))
+}
+
+// Inc adds 1 to a metric. It is shorthand for Add(k, 1).
+func (m *Metrics) Inc(k string) {
+	m.Add(k, 1)
+}
+
+// Dec subtracts 1 from a metric. It is shorthand for Add(k, -1).
+func (m *Metrics) Dec(k string) {
+	m.Add(k, -1)
+}
+
+// Accumulate adds 1 to a counter named after each of the events, in
+// a single locked pass. For example, accumulating a batch of HTTP
+// status codes yields a count for each code.
+func (m *Metrics) Accumulate(events []string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	for _, k := range events {
+		m.add(k, 1, math.Inf(-1), math.Inf(1))
+	}
+}
+
+// AddWithCap behaves like Add, but the resulting value of the metric
+// never exceeds cap.
+func (m *Metrics) AddWithCap(k string, n, cap float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	m.add(k, n, math.Inf(-1), cap)
+}
+
+// AddWithFloor behaves like Add, but the resulting value of the metric
+// never falls below floor.
+func (m *Metrics) AddWithFloor(k string, n, floor float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	m.add(k, n, floor, math.Inf(1))
+}
+
+// AddClamped behaves like Add, but a change, n, larger in magnitude
+// than maxStep is reduced to maxStep. It returns the change that was
+// applied. This guards gauges feeding alerts against the occasional
+// absurd input.
+func (m *Metrics) AddClamped(k string, n, maxStep float64) float64 {
+	if m == nil {
+		return 0
+	}
+	maxStep = math.Abs(maxStep)
+	n = math.Max(-maxStep, math.Min(maxStep, n))
+	m.mu.Lock()
+	defer m.unlock()
+	m.add(k, n, math.Inf(-1), math.Inf(1))
+	return n
+}
+
+// AddFloat32 behaves like Add, but a metric that was absent or not
+// previously numerical becomes a float32.
+func (m *Metrics) AddFloat32(k string, n float32) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if _, err := AsNumber(m.Detail[k]); err != nil {
+		m.set(k, n)
+		return
+	}
+	m.add(k, float64(n), math.Inf(-1), math.Inf(1))
+}
+
+// add adds n to metric k, limiting the result to the range [lo, hi].
+// The caller must hold m.mu.
+func (m *Metrics) add(k string, n, lo, hi float64) {
+	if c, ok := m.Detail[k].(*cell); ok {
+		c.add(n, lo, hi)
+		m.set(k, c)
+		return
+	}
+	m.set(k, addNumber(m.Detail[k], n, lo, hi))
+}
+
+// addNumber returns the result of adding n to the metric value was,
+// limited to the range [lo, hi]. The type of the result follows the
+// policy described for Add.
+func addNumber(was interface{}, n, lo, hi float64) interface{} {
+	if tv, ok := was.(TimedValue); ok {
+		was = tv.V
+	}
+	x, err := AsNumber(was)
+	if err != nil {
+		return math.Max(lo, math.Min(hi, n))
+	}
+	sum := math.Max(lo, math.Min(hi, x+n))
+	switch was.(type) {
+	case float64:
+		return sum
+	case float32:
+		return float32(sum)
+	}
+	i, ok := AsInt64(was)
+	if !ok || n != math.Trunc(n) || math.Abs(n) >= 1<<53 {
+		return sum
+	}
+	r := i + int64(n)
+	if (n >= 0) != (r >= i) {
+		return sum
+	}
+	if f := float64(r); f < lo || f > hi {
+		if sum != math.Trunc(sum) || math.Abs(sum) >= 1<<53 {
+			return sum
+		}
+		r = int64(sum)
+	}
+	if v, ok := intLike(was, r); ok {
+		return v
+	}
+	return sum
+}
+
+// intLike returns r as a value of the same integer type as v, if it
+// fits that type.
+func intLike(v interface{}, r int64) (interface{}, bool) {
+	switch v.(type) {
+	case int:
+		if r < math.MinInt || r > math.MaxInt {
+			return nil, false
+		}
+		return int(r), true
+	case int32:
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil, false
+		}
+		return int32(r), true
+	case int64:
+		return r, true
+	case uint:
+		if r < 0 || uint64(r) > math.MaxUint {
+			return nil, false
+		}
+		return uint(r), true
+	case uint32:
+		if r < 0 || r > math.MaxUint32 {
+			return nil, false
+		}
+		return uint32(r), true
+	case uint64:
+		if r < 0 {
+			return nil, false
+		}
+		return uint64(r), true
+	case time.Duration:
+		return time.Duration(r), true
+	default:
+		return nil, false
+	}
+}
+
+// Touch ensures metric k exists. If it is absent, it is created with
+// a numerical value of zero. Existing values are left untouched. This
+// is useful to pre-register counters, so they are reported before
+// their first increment.
+func (m *Metrics) Touch(k string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if _, ok := m.Detail[k]; !ok {
+		m.set(k, float64(0))
+	}
+}
+
+// DrainInto copies all of the metric values into dst and zeroes the
+// numerical metrics, in a single atomic step, so no update can be
+// lost between the copy and the reset. The values are copied as for
+// Snap, so dst shares no state with m. Non-numerical values are
+// copied but left unchanged. Zeroing a metric does not count as an
+// update of it, see LastUpdated, so draining does not keep idle
+// metrics from being pruned or evicted. The dst map must not be nil.
+func (m *Metrics) DrainInto(dst map[string]interface{}) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.capture(dst, func(string) bool { return true })
+	m.unlock()
+	resolveFuncs(dst)
+}
+
+// SumKeys sets metric dest to the float64 sum of the numerical
+// metrics srcs, in a single atomic step. If any of srcs is absent or
+// not numerical, SumKeys fails with an error wrapping ErrNotFound or
+// ErrNotNumber, and dest is left unchanged.
+func (m *Metrics) SumKeys(dest string, srcs ...string) error {
+	return m.sumKeys(dest, srcs, false)
+}
+
+// SumNumericKeys is the same as SumKeys, but any of srcs that are
+// absent or not numerical are left out of the sum.
+func (m *Metrics) SumNumericKeys(dest string, srcs ...string) error {
+	return m.sumKeys(dest, srcs, true)
+}
+
+// sumKeys implements SumKeys and, when skip is true, SumNumericKeys.
+func (m *Metrics) sumKeys(dest string, srcs []string, skip bool) error {
+	if m == nil {
+		return ErrInvalid
+	}
+	// The values of computed metrics, such as those set with
+	// SetFunc, are computed first, since their functions cannot be
+	// called under the lock.
+	values := make(map[string]interface{})
+	m.mu.RLock()
+	for _, k := range srcs {
+		if c, ok := m.Detail[k].(computed); ok {
+			values[k] = c
+		}
+	}
+	m.mu.RUnlock()
+	resolveFuncs(values)
+	m.mu.Lock()
+	defer m.unlock()
+	sum := 0.0
+	for _, k := range srcs {
+		v, ok := m.Detail[k]
+		if _, isComputed := v.(computed); isComputed {
+			if c, done := values[k]; done {
+				v = c
+			}
+		}
+		if !ok {
+			if skip {
+				continue
+			}
+			return fmt.Errorf("metric %q: %w", k, ErrNotFound)
+		}
+		n, err := AsNumber(v)
+		if err != nil {
+			if skip {
+				continue
+			}
+			return fmt.Errorf("metric %q: %w", k, err)
+		}
+		sum += n
+	}
+	return m.set(dest, sum)
+}
+
+// AddDuration adds d to a metric holding a time.Duration or, in the
+// case the metric did not previously hold a time.Duration, it replaces
+// the metric with d. Unlike Add, the stored value remains a
+// time.Duration.
+func (m *Metrics) AddDuration(k string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if x, ok := m.Detail[k].(time.Duration); ok {
+		d += x
+	}
+	m.set(k, d)
+}
+
+// GetDuration returns the value of a metric holding a time.Duration
+// or, in the case the metric does not hold one, ErrNotDuration.
+func (m *Metrics) GetDuration(k string) (time.Duration, error) {
+	v := m.Get(k)
+	if tv, ok := v.(TimedValue); ok {
+		v = tv.V
+	}
+	if d, ok := v.(time.Duration); ok {
+		return d, nil
+	}
+	return 0, ErrNotDuration
+}
+
+// GetTime returns the value of a metric holding a time. The metric
+// can hold a time.Time, an RFC3339 formatted string or a number of
+// seconds since the Unix epoch. For any other value, GetTime returns
+// ErrNotTime.
+func (m *Metrics) GetTime(k string) (time.Time, error) {
+	v := m.Get(k)
+	if tv, ok := v.(TimedValue); ok {
+		v = tv.V
+	}
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, x); err == nil {
+			return t, nil
+		}
+		return time.Time{}, ErrNotTime
+	}
+	if secs, ok := AsInt64(v); ok {
+		if _, isDuration := v.(time.Duration); !isDuration {
+			return time.Unix(secs, 0), nil
+		}
+	} else if secs, err := AsNumber(v); err == nil {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+	return time.Time{}, ErrNotTime
+}
+
+// DumpMDTable returns a byte array of markdown text that represents a
+// table of the current values of all the metrics.
+func (m *Metrics) DumpMDTable() []byte {
+	d, _ := m.DumpMDTableWithOptions(nil)
+	return d
+}
+
+// DumpMDTableWithOptions is the same as DumpMDTable, but the values
+// it renders are adjusted according to opts.
+func (m *Metrics) DumpMDTableWithOptions(opts *WriteOptions) ([]byte, error) {
+	if m == nil {
+		return nil, nil
+	}
+	m.Collect()
+	if opts.resetOnRead() {
+		s := m.snap(true)
+		return MDTable(s.Values, s.When, opts)
+	}
+	// The table is rendered directly from the live metrics, to
+	// avoid the cost of copying them all for large instances. Only
+	// the rows are gathered under the lock, since formatters and
+	// the String methods of values may use m.
+	m.mu.RLock()
+	when := time.Now()
+	rows := make([]mdValue, 0, len(m.Detail))
+	for k, v := range m.Detail {
+		if dv, ok := v.(derivedValue); ok {
+			for suffix, d := range dv.derived() {
+				rows = append(rows, mdValue{k: k + suffix, v: d})
+			}
+			continue
+		}
+		rows = append(rows, mdValue{k: k, v: v, f: m.display(k)})
+	}
+	m.mu.RUnlock()
+	sort.Slice(rows, func(a, b int) bool {
+		return rows[a].k < rows[b].k
+	})
+	var b strings.Builder
+	b.Grow(64 + 32*len(rows))
+	mdHeader(&b, when)
+	for _, r := range rows {
+		if err := mdRow(&b, r.k, resolve(r.v), r.f, when, opts); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// mdValue holds a metric to be rendered as a markdown table row.
+type mdValue struct {
+	k string
+	v interface{}
+	f func(interface{}) string
+}
+
+// MDTable returns a byte array of markdown text that represents a
+// table of the values of all the metrics of r, as of time when. The
+// values are adjusted according to opts.
+func MDTable(r Reader, when time.Time, opts *WriteOptions) ([]byte, error) {
+	ks := r.Keys()
+	sort.Strings(ks)
+	var b strings.Builder
+	mdHeader(&b, when)
+	for _, k := range ks {
+		if err := mdRow(&b, k, r.Get(k), formatterOf(r, k), when, opts); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// mdHeader writes the header of a markdown table of metrics, as of
+// time when, to b.
+func mdHeader(b *strings.Builder, when time.Time) {
+	fmt.Fprintf(b, "key | value at %s\n----|------\n", when.Format(time.UnixDate))
+}
+
+// mdRow writes the markdown table row for metric k, with value v,
+// to b. The value is rendered with the formatter f, if it is not nil,
+// and is otherwise adjusted according to opts.
+func mdRow(b *strings.Builder, k string, v interface{}, f func(interface{}) string, when time.Time, opts *WriteOptions) error {
+	v, ok, err := opts.finiteValue(v)
+	if err != nil {
+		return fmt.Errorf("metric %q: %w", k, err)
+	}
+	if !ok {
+		return nil
+	}
+	b.WriteString(k)
+	b.WriteString(" | ")
+	if _, timed := v.(TimedValue); f == nil && !timed {
+		var buf [32]byte
+		b.Write(opts.appendText(buf[:0], v))
+	} else {
+		b.WriteString(mdText(v, f, when, opts))
+	}
+	b.WriteString("\n")
+	return nil
+}
+
+// mdText renders the value v of a metric for a markdown table, as of
+// time when. The value is rendered with the formatter f, if it is not
+// nil, and is otherwise rendered according to opts.
+func mdText(v interface{}, f func(interface{}) string, when time.Time, opts *WriteOptions) string {
+	if f != nil {
+		return f(v)
+	}
+	if tv, ok := v.(TimedValue); ok {
+		return fmt.Sprintf("%s (age %v)", opts.text(tv.V), when.Sub(tv.When).Round(time.Millisecond))
+	}
+	return opts.text(v)
+}
+
+// DumpMDTableWithRate is the same as DumpMDTable, but the table has a
+// third column holding the per second rate of change of each
+// numerical metric since the prev snapshot. The rate is blank for
+// non-numerical metrics and for metrics absent from prev, or if prev
+// is nil.
+func (m *Metrics) DumpMDTableWithRate(prev *Snapshot) []byte {
+	if m == nil {
+		return nil
+	}
+	m.Collect()
+	f := m.Freeze()
+	var b strings.Builder
+	fmt.Fprintf(&b, "key | value at %s | rate/s\n----|------|------\n", f.When().Format(time.UnixDate))
+	for _, k := range f.Keys() {
+		v := f.Get(k)
+		rate := ""
+		if n, err := AsNumber(v); err == nil && prev != nil {
+			if p, err := AsNumber(prev.Values.Detail[k]); err == nil {
+				rate = (*WriteOptions)(nil).text(Rate(Sample{prev.When, p}, Sample{f.When(), n}))
+			}
+		}
+		fmt.Fprintf(&b, "%s | %s | %s\n", k, mdText(v, f.formatter(k), f.When(), nil), rate)
+	}
+	return []byte(b.String())
+}
+
+// Snapshot holds a timestamped snapshot of metrics.
+type Snapshot struct {
+	When   time.Time
+	Values *Metrics
 }
 
-// New establishes a group of metrics.
-func New() *Metrics {
-	return &Metrics{Detail: make(map[string]interface{})}
+// Age returns how long before now the snapshot was taken. A
+// snapshot taken after now, for example, one recorded on a host with
+// a clock that is ahead, has a negative age.
+func (s *Snapshot) Age(now time.Time) time.Duration {
+	return now.Sub(s.When)
 }
 
-// ErrInvalid and ErrNotNumber are standard errors returned by this
-// package.
-var (
-	ErrInvalid   = errors.New("undefined metrics")
-	ErrNotNumber = errors.New("not a number")
-	ErrNotFound  = errors.New("not found")
-)
+// Get returns the value of metric k in the snapshot, or nil if it is
+// absent.
+func (s *Snapshot) Get(k string) interface{} {
+	return s.Values.Get(k)
+}
 
-// Set sets the value of a specific metric.
-func (m *Metrics) Set(k string, value interface{}) error {
-	if m == nil {
-		return ErrInvalid
-	}
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.Detail[k] = value
-	return nil
+// GetNumber returns the numerical value of metric k in the snapshot,
+// as for Metrics.GetNumber.
+func (s *Snapshot) GetNumber(k string) (float64, error) {
+	return s.Values.GetNumber(k)
 }
 
-// Get returns the current value of a specific metric.
-func (m *Metrics) Get(k string) interface{} {
-	if m == nil {
-		return nil
+// ValuesHash returns a hash of the metric values of the snapshot,
+// ignoring its time. Snapshots holding the same keys with equal
+// values, of the same types, have the same hash.
+func (s *Snapshot) ValuesHash() uint64 {
+	ks := make([]string, 0, len(s.Values.Detail))
+	for k := range s.Values.Detail {
+		ks = append(ks, k)
 	}
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	return m.Detail[k]
+	sort.Strings(ks)
+	h := fnv.New64a()
+	var n [binary.MaxVarintLen64]byte
+	for _, k := range ks {
+		v := s.Values.Detail[k]
+		for _, x := range []string{k, fmt.Sprintf("%T", v), fmt.Sprint(v)} {
+			h.Write(n[:binary.PutUvarint(n[:], uint64(len(x)))])
+			h.Write([]byte(x))
+		}
+	}
+	return h.Sum64()
 }
 
-// AsNumber returns a numerical value for an interface{} value, or an
-// error.
-func AsNumber(v interface{}) (float64, error) {
-	switch v.(type) {
-	case int:
-		return float64(v.(int)), nil
-	case int32:
-		return float64(v.(int32)), nil
-	case int64:
-		return float64(v.(int64)), nil
-	case uint:
-		return float64(v.(uint)), nil
-	case uint32:
-		return float64(v.(uint32)), nil
-	case uint64:
-		return float64(v.(uint64)), nil
-	case float64:
-		return v.(float64), nil
-	default:
-		return 0, ErrNotNumber
+// Sub returns a snapshot, taken at s.When, of the change in each
+// numerical metric of s since prev. Metrics absent from prev are
+// treated as having been 0, and non-numerical metrics of s are
+// omitted. It is an error for a numerical metric of s to be
+// non-numerical in prev.
+func (s *Snapshot) Sub(prev *Snapshot) (*Snapshot, error) {
+	if s == nil || prev == nil {
+		return nil, ErrInvalid
+	}
+	d := &Snapshot{When: s.When, Values: New()}
+	for k, x := range s.Values.Detail {
+		v, err := AsNumber(x)
+		if err != nil {
+			continue
+		}
+		if y, ok := prev.Values.Detail[k]; ok {
+			w, err := AsNumber(y)
+			if err != nil {
+				return nil, fmt.Errorf("previous %q = %v: %w", k, y, err)
+			}
+			v -= w
+		}
+		d.Values.Detail[k] = v
 	}
+	return d, nil
 }
 
-// GetNumber returns the numerical value of a metric or, in the case
-// the metric is not a number, it indicates this with an error value.
-func (m *Metrics) GetNumber(k string) (float64, error) {
-	if m == nil {
-		return 0, ErrNotNumber
+// DiffReport returns a human readable report of the differences
+// between the metrics a and b, one line per metric, in key order.
+// Metrics only present in b are reported as "+ k = v", those only
+// present in a as "- k = v", and metrics with different values, or
+// values of different types, as "~ k: old -> new". The report is
+// empty if there are no differences. A nil *Metrics holds no metrics.
+func DiffReport(a, b *Metrics) string {
+	va, vb := a.values(), b.values()
+	ks := make([]string, 0, len(va)+len(vb))
+	for k := range va {
+		ks = append(ks, k)
 	}
-	m.mu.Lock()
-	v := m.Detail[k]
-	m.mu.Unlock()
-	return AsNumber(v)
+	for k := range vb {
+		if _, ok := va[k]; !ok {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	var opts *WriteOptions
+	var r strings.Builder
+	for _, k := range ks {
+		x, inA := va[k]
+		y, inB := vb[k]
+		switch {
+		case !inA:
+			fmt.Fprintf(&r, "+ %s = %s\n", k, opts.text(y))
+		case !inB:
+			fmt.Fprintf(&r, "- %s = %s\n", k, opts.text(x))
+		case fmt.Sprintf("%T %v", x, x) != fmt.Sprintf("%T %v", y, y):
+			fmt.Fprintf(&r, "~ %s: %s -> %s\n", k, opts.text(x), opts.text(y))
+		}
+	}
+	return r.String()
 }
 
-// Add adds a number to a metric or, in the case the metric was not
-// previously numerical, it replaces the metric with the provided
-// number, n.
-func (m *Metrics) Add(k string, n float64) {
-	if m == nil {
-		return
+// ApplyDelta adds each numerical value of d to the same metric of m,
+// as for Add, in a single atomic step. Non-numerical values of d
+// replace the values of their metrics. This is the inverse of Sub: it
+// rebuilds running totals from a series of deltas.
+func (m *Metrics) ApplyDelta(d *Snapshot) error {
+	if m == nil || d == nil {
+		return ErrInvalid
 	}
+	delta := d.Values.values()
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	x, ok := m.Detail[k]
-	v, err := AsNumber(x)
-	if !ok || err != nil {
-		m.Detail[k] = n
-	} else {
-		m.Detail[k] = n + v
+	defer m.unlock()
+	for k, v := range delta {
+		if n, err := AsNumber(v); err == nil {
+			m.add(k, n, math.Inf(-1), math.Inf(1))
+		} else if err := m.set(k, v); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-// DumpMDTable returns a byte array of markdown text that represents a
-// table of the current values of all the metrics.
-func (m *Metrics) DumpMDTable() []byte {
-	if m == nil {
-		return nil
-	}
+// Snap snapshots all of the current metric values. Any *Histogram
+// value is copied, so the snapshot is unaffected by later
+// observations. *Summary and *Meter values are replaced by their
+// derived numerical metrics. As for the outputs of m, the metrics are
+// first updated by the collectors of m, see AddCollector.
+func (m *Metrics) Snap() *Snapshot {
+	m.Collect()
+	return m.snap(false)
+}
+
+// SnapFunc snapshots the metrics for which pred returns true. The
+// snapshot is of a single point in time, but, so pred may itself use
+// m, it is evaluated after the values are captured and without
+// holding any lock.
+func (m *Metrics) SnapFunc(pred func(k string, v interface{}) bool) *Snapshot {
 	s := m.Snap()
-	var ks []string
-	for x := range s.Values.Detail {
-		ks = append(ks, x)
+	for k, v := range s.Values.Detail {
+		if !pred(k, v) {
+			delete(s.Values.Detail, k)
+		}
 	}
-	sort.Strings(ks)
+	return s
+}
 
-	for i, x := range ks {
-		ks[i] = fmt.Sprintf("%s | %v", x, s.Values.Detail[x])
+// InRange returns a new Metrics holding the numerical metrics of m
+// with values, as converted by AsNumber, in the range [min, max].
+// The values are copied unchanged. Non-numerical metrics are
+// excluded.
+func (m *Metrics) InRange(min, max float64) *Metrics {
+	r := New()
+	if m == nil {
+		return r
+	}
+	m.mu.RLock()
+	d := make(map[string]interface{}, len(m.Detail))
+	for k, v := range m.Detail {
+		d[k] = v
+	}
+	m.mu.RUnlock()
+	resolveFuncs(d)
+	for k, v := range d {
+		if n, err := AsNumber(v); err == nil && n >= min && n <= max {
+			r.Detail[k] = v
+		}
 	}
+	return r
+}
 
-	return []byte(strings.Join(append([]string{fmt.Sprintf("key | value at %s\n----|------", s.When.Format(time.UnixDate))}, ks...), "\n") + "\n")
+// SnapAligned is the same as Snap, but the snapshot time is rounded
+// down to a multiple of interval since the Unix epoch. Processes
+// snapping on the same schedule thus record identical times. Several
+// snapshots taken within one interval have the same time. An interval
+// that is not positive leaves the time unchanged.
+func (m *Metrics) SnapAligned(interval time.Duration) *Snapshot {
+	s := m.Snap()
+	if interval > 0 {
+		n := s.When.UnixNano()
+		s.When = time.Unix(0, n-n%int64(interval))
+	}
+	return s
 }
 
-// Snapshot holds a timestamped snapshot of metrics.
-type Snapshot struct {
-	When   time.Time
-	Values *Metrics
+// SnapAll snapshots each of ms, giving all of the returned snapshots
+// the same When time, so snapshots of independent sets of metrics,
+// such as one per shard, align exactly.
+func SnapAll(ms ...*Metrics) []*Snapshot {
+	snaps := make([]*Snapshot, len(ms))
+	when := time.Now()
+	for i, m := range ms {
+		snaps[i] = m.Snap()
+		snaps[i].When = when
+	}
+	return snaps
 }
 
-// Snap snapshots all of the current metric values.
-func (m *Metrics) Snap() *Snapshot {
+// snap snapshots all of the current metric values. If reset is true,
+// the counters, as described for ResetOnRead, are zeroed as they are
+// captured.
+func (m *Metrics) snap(reset bool) *Snapshot {
 	s := &Snapshot{
 		Values: New(),
 	}
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	// Deferred first, so it runs after the lock is released.
+	defer resolveFuncs(s.Values.Detail)
+	if reset {
+		m.mu.Lock()
+		defer m.unlock()
+	} else {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+	}
 	s.When = time.Now()
-	for k, v := range m.Detail {
-		s.Values.Detail[k] = v
+	var zero func(string) bool
+	if reset {
+		zero = m.resets
+	}
+	m.capture(s.Values.Detail, zero)
+	if len(m.formats) != 0 {
+		s.Values.formats = make(map[string]func(interface{}) string)
+		for k, f := range m.formats {
+			s.Values.formats[k] = f
+		}
+	}
+	if len(m.meta) != 0 {
+		s.Values.meta = make(map[string]Meta)
+		for k, d := range m.meta {
+			s.Values.meta[k] = d
+		}
 	}
 	return s
 }
 
+// capture copies the current metric values into dst, as for Snap:
+// each *Histogram is copied, the derived values of each derivedValue
+// are captured in its place, and handle cells are read. If zero is not
+// nil, the numerical metrics for which it returns true are zeroed as
+// they are captured. They are zeroed in place, so this does not count
+// as an update, see LastUpdated. The caller must hold m.mu, for
+// writing if zero is not nil.
+func (m *Metrics) capture(dst map[string]interface{}, zero func(k string) bool) {
+	var derived []string
+	zeroed := false
+	for k, v := range m.Detail {
+		switch x := v.(type) {
+		case *Histogram:
+			v = x.clone()
+		case *cell:
+			if zero != nil && zero(k) {
+				dst[k] = x.swap(0)
+				zeroed = true
+				continue
+			}
+			v = x.load()
+		}
+		if _, ok := v.(derivedValue); ok {
+			derived = append(derived, k)
+			continue
+		}
+		dst[k] = v
+		if zero == nil || !zero(k) {
+			continue
+		}
+		if z, ok := zeroLike(v); ok {
+			m.Detail[k] = z
+			zeroed = true
+		}
+	}
+	for _, k := range derived {
+		for suffix, v := range m.Detail[k].(derivedValue).derived() {
+			dst[k+suffix] = v
+		}
+	}
+	if zeroed {
+		m.notify()
+	}
+}
+
+// resets reports whether metric k is a counter, zeroed by outputs
+// that select ResetOnRead. The caller must hold m.mu.
+func (m *Metrics) resets(k string) bool {
+	if m.collected[k] {
+		return false
+	}
+	switch m.meta[k].Kind {
+	case "", KindUntyped, KindCounter:
+		return true
+	}
+	return false
+}
+
+// derivedValue is implemented by metric values, such as *Summary,
+// that are represented in snapshots and markdown dumps by derived
+// numerical metrics. derived returns them keyed by the suffix appended
+// to the key of the metric holding the value.
+type derivedValue interface {
+	derived() map[string]interface{}
+}
+
 // Trim removes redundant entries from an array of Snapshots.  The
 // returned value includes the most recently valid timestamp for all
 // entries. That is, the most recent snapshot of the trimmed slice is
 // a full snapshot. The slice is edited in place and the length of the
-// slice may also reduce.
+// slice may also reduce. Values are unchanged if their fmt.Sprint
+// text is the same.
 func Trim(snaps []*Snapshot) (results []*Snapshot) {
-	latest := make(map[string]string)
+	return TrimFunc(snaps, func(a, b interface{}) bool {
+		return fmt.Sprint(a) == fmt.Sprint(b)
+	})
+}
+
+// TrimFunc is the same as Trim, but a value is unchanged if equal
+// returns true for it and the previously retained value of its
+// metric. Since values are compared with the retained value, rather
+// than the one immediately before, an approximate equal, such as one
+// with a tolerance, cannot let a metric drift unrecorded.
+func TrimFunc(snaps []*Snapshot, equal func(a, b interface{}) bool) []*Snapshot {
+	latest := make(map[string]interface{})
 	for i := 0; i < len(snaps)-1; i++ {
 		m := snaps[i].Values
 		var ks []string
-		m.mu.Lock()
-		for k, v := range m.Detail {
-			s := fmt.Sprint(v)
-			if latest[k] == s {
+		for k, v := range m.values() {
+			if was, ok := latest[k]; ok && equal(was, v) {
 				ks = append(ks, k)
 			} else {
-				latest[k] = s
+				latest[k] = v
 			}
 		}
+		m.mu.Lock()
 		for _, k := range ks {
 			delete(m.Detail, k)
 		}
-		m.mu.Unlock()
+		m.unlock()
 		if len(m.Detail) == 0 {
 			snaps = append(snaps[:i], snaps[i+1:]...)
 			i--
 		}
 	}
-	results = snaps
-	return
+	return snaps
 }
 
 // Infer returns the most current value for a specified key at the
-// requested time, indicating the time when the returned value was
-// recorded.
+// requested time, indicating the index of the snapshot that recorded
+// it. Snapshots that do not hold the key, such as those thinned by
+// Trim, are skipped.
 func Infer(snaps []*Snapshot, t time.Time, k string) (index int, v interface{}, err error) {
 	if len(snaps) == 0 || snaps[0].When.After(t) {
 		err = ErrNotFound
@@ -189,7 +1439,7 @@ func Infer(snaps []*Snapshot, t time.Time, k string) (index int, v interface{},
 		return snaps[a].When.After(t)
 	})
 	var ok bool
-	for i := before - 1; before > 0; before-- {
+	for i := before - 1; i >= 0; i-- {
 		if v, ok = snaps[i].Values.Detail[k]; ok {
 			index = i
 			return
@@ -199,63 +1449,395 @@ func Infer(snaps []*Snapshot, t time.Time, k string) (index int, v interface{},
 	return
 }
 
+// InferWhen is the same as Infer, but it returns the time the
+// returned value was recorded. For a TimedValue, this is its own
+// measurement time, and the returned value is its V value. For all
+// other values, it is the time of the snapshot holding the value.
+func InferWhen(snaps []*Snapshot, t time.Time, k string) (when time.Time, v interface{}, err error) {
+	var index int
+	if index, v, err = Infer(snaps, t, k); err != nil {
+		return
+	}
+	if tv, ok := v.(TimedValue); ok {
+		return tv.When, tv.V, nil
+	}
+	return snaps[index].When, v, nil
+}
+
+// InferRange is the same as Infer, but it returns the interval,
+// [start, end), over which the returned value was the current value
+// of k. The interval starts with the earliest snapshot of an unbroken
+// run holding the same value, so it is the same whether or not snaps
+// have been trimmed. It ends with the next snapshot holding a
+// different value for k or, if there is none, with the time of the
+// last snapshot.
+func InferRange(snaps []*Snapshot, t time.Time, k string) (start, end time.Time, v interface{}, err error) {
+	var index int
+	if index, v, err = Infer(snaps, t, k); err != nil {
+		return
+	}
+	text := fmt.Sprint(v)
+	first := index
+	for i := index - 1; i >= 0; i-- {
+		if x, ok := snaps[i].Values.Detail[k]; ok {
+			if fmt.Sprint(x) != text {
+				break
+			}
+			first = i
+		}
+	}
+	start, end = snaps[first].When, snaps[len(snaps)-1].When
+	for i := index + 1; i < len(snaps); i++ {
+		if x, ok := snaps[i].Values.Detail[k]; ok && fmt.Sprint(x) != text {
+			end = snaps[i].When
+			break
+		}
+	}
+	return
+}
+
+// ExtractNumbersWithOptions is the same as ExtractNumbers, but the
+// returned values are adjusted according to opts. When the NonFinite
+// policy of opts is NonFiniteSkip, any row holding a non-finite value
+// is omitted.
+func ExtractNumbersWithOptions(snaps []*Snapshot, timeunits time.Duration, from, to time.Time, vars []string, opts *WriteOptions) ([][]float64, error) {
+	lines, _, err := extractRows(snaps, timeunits, from, to, vars, opts, false)
+	return lines, err
+}
+
+// extractRows implements ExtractNumbersWithOptions. If exact is true,
+// it also returns, for each row, the exact values of the integer
+// metrics, as described for exactValue.
+func extractRows(snaps []*Snapshot, timeunits time.Duration, from, to time.Time, vars []string, opts *WriteOptions, exact bool) ([][]float64, [][]interface{}, error) {
+	e, start, err := newExtraction(snaps, timeunits, from, to, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exact {
+		// Only the row of the start of the range has been emitted.
+		e.exacts = [][]interface{}{e.exactRow()}
+	}
+	for i := start; i < len(snaps) && !e.done; i++ {
+		if err := e.step(i, snaps[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts == nil {
+		return e.lines, e.exacts, nil
+	}
+	var kept [][]float64
+	var keptExact [][]interface{}
+	for i, line := range e.lines {
+		ok := true
+		for j := 1; ok && j < len(line); j++ {
+			var err error
+			line[j], ok, err = opts.finite(line[j])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%q at %v: %w", vars[j-1], line[0], err)
+			}
+		}
+		if ok {
+			kept = append(kept, line)
+			if exact {
+				keptExact = append(keptExact, e.exacts[i])
+			}
+		}
+	}
+	return kept, keptExact, nil
+}
+
+// exactValue returns the exact value of an integer metric value, as
+// an int64 or, for larger unsigned values, a uint64, or nil if v is
+// not an integer. Unlike the float64 values of ExtractNumbers, these
+// hold counters above 2^53 exactly.
+func exactValue(v interface{}) interface{} {
+	if tv, ok := v.(TimedValue); ok {
+		v = tv.V
+	}
+	switch u := v.(type) {
+	case uint:
+		return uint64(u)
+	case uint64:
+		return u
+	}
+	if i, ok := AsInt64(v); ok {
+		return i
+	}
+	return nil
+}
+
+// extraction holds the state of an ExtractNumbers scan over a single
+// time range.
+type extraction struct {
+	timeunits  time.Duration
+	to         time.Time
+	vars       []string
+	values     map[string]float64
+	ts, lastTS float64
+	done       bool
+	lines      [][]float64
+	// raw holds the current value of each var, as read, and exacts,
+	// when not nil, the exact integer values of each of lines, see
+	// exactValue.
+	raw    map[string]interface{}
+	exacts [][]interface{}
+}
+
+// newExtraction starts the extraction of vars from snaps over the
+// time range from to to. It returns the extraction along with the
+// index of the first snapshot that should be passed to its step
+// method.
+func newExtraction(snaps []*Snapshot, timeunits time.Duration, from, to time.Time, vars []string) (*extraction, int, error) {
+	start := sort.Search(len(snaps), func(a int) bool {
+		return snaps[a].When.After(from)
+	})
+	e, err := startExtraction(timeunits, from, to, vars, func(k string) (interface{}, error) {
+		_, v, err := Infer(snaps, from, k)
+		return v, err
+	})
+	return e, start, err
+}
+
+// startExtraction starts the extraction of vars over the time range
+// from to to, where infer returns the value of a var at time from.
+// The snapshots to pass to the step method of the extraction are
+// those taken after from.
+func startExtraction(timeunits time.Duration, from, to time.Time, vars []string, infer func(k string) (interface{}, error)) (*extraction, error) {
+	e := &extraction{
+		timeunits: timeunits,
+		to:        to,
+		vars:      vars,
+		values:    make(map[string]float64),
+		raw:       make(map[string]interface{}),
+	}
+	for _, k := range vars {
+		v, err := infer(k)
+		if err != nil {
+			return nil, fmt.Errorf("error for %q at %v: %w", k, from, err)
+		}
+		n, err := AsNumber(v)
+		if err != nil {
+			return nil, fmt.Errorf("error for %q at %v: %w", k, from, err)
+		}
+		e.values[k] = n
+		e.raw[k] = v
+	}
+	e.ts = float64(from.UnixNano() / int64(timeunits))
+	e.emit()
+	return e, nil
+}
+
+// emit appends a row of the current values to the extraction. A row
+// with the same timestamp as the previous one replaces it.
+func (e *extraction) emit() {
+	vs := []float64{e.ts}
+	for _, k := range e.vars {
+		vs = append(vs, e.values[k])
+	}
+	replace := len(e.lines) != 0 && e.ts == e.lastTS
+	if replace {
+		e.lines[len(e.lines)-1] = vs
+	} else {
+		e.lines = append(e.lines, vs)
+	}
+	if e.exacts != nil {
+		if replace {
+			e.exacts[len(e.exacts)-1] = e.exactRow()
+		} else {
+			e.exacts = append(e.exacts, e.exactRow())
+		}
+	}
+	e.lastTS = e.ts
+}
+
+// exactRow returns the exact values of the current values of the vars,
+// see exactValue.
+func (e *extraction) exactRow() []interface{} {
+	row := make([]interface{}, len(e.vars))
+	for j, k := range e.vars {
+		row[j] = exactValue(e.raw[k])
+	}
+	return row
+}
+
+// step advances the extraction over snapshot i, s. Once the end of
+// the time range has been reached, e.done is true and the remaining
+// snapshots are ignored.
+func (e *extraction) step(i int, s *Snapshot) error {
+	if e.done {
+		return nil
+	}
+	if !s.When.Before(e.to) {
+		e.done = true
+		if tts := float64(e.to.UnixNano() / int64(e.timeunits)); e.ts != tts {
+			e.ts = tts
+			e.emit()
+		}
+		return nil
+	}
+	e.ts = float64(s.When.UnixNano() / int64(e.timeunits))
+	for _, k := range e.vars {
+		x, ok := s.Values.Detail[k]
+		if !ok {
+			continue
+		}
+		v, err := AsNumber(x)
+		if err != nil {
+			return fmt.Errorf("snapshot[%d][%q] = %v: %w", i, k, x, err)
+		}
+		e.values[k] = v
+		e.raw[k] = x
+	}
+	e.emit()
+	return nil
+}
+
 // ExtractNumbers returns an array of number values. The first column
 // holds the number of timeunits since the epoch associated with the
 // measured value.
 func ExtractNumbers(snaps []*Snapshot, timeunits time.Duration, from, to time.Time, vars []string) ([][]float64, error) {
-	starts := make(map[string]float64)
-	var minI int
-	for j, k := range vars {
-		i, v, err := Infer(snaps, from, k)
-		if err != nil {
-			return nil, fmt.Errorf("error for %q at %v: %v", k, from, err)
+	e, start, err := newExtraction(snaps, timeunits, from, to, vars)
+	if err != nil {
+		return nil, err
+	}
+	for i := start; i < len(snaps) && !e.done; i++ {
+		if err := e.step(i, snaps[i]); err != nil {
+			return nil, err
 		}
-		n, err := AsNumber(v)
-		if err != nil {
-			return nil, fmt.Errorf("error for %q at %v: %v", k, from, err)
+	}
+	return e.lines, nil
+}
+
+// ExtractRates is the same as ExtractNumbers, but the columns of the
+// vars listed in counters hold the rate of change of the metric, per
+// timeunit, since the previous row. Since the first row has no
+// previous row, it is omitted. A counter value that decreases is
+// taken to be a counter restarted from zero, so its change is the
+// new value itself.
+func ExtractRates(snaps []*Snapshot, timeunits time.Duration, from, to time.Time, vars []string, counters map[string]bool) ([][]float64, error) {
+	lines, err := ExtractNumbers(snaps, timeunits, from, to, vars)
+	if err != nil || len(lines) == 0 {
+		return nil, err
+	}
+	rates := make([][]float64, len(lines)-1)
+	for i := 1; i < len(lines); i++ {
+		prev, line := lines[i-1], lines[i]
+		dt := line[0] - prev[0]
+		row := append([]float64(nil), line...)
+		for j, k := range vars {
+			if !counters[k] {
+				continue
+			}
+			d := line[j+1] - prev[j+1]
+			if d < 0 {
+				d = line[j+1]
+			}
+			row[j+1] = d / dt
 		}
-		if j == 0 || i > minI {
-			minI = i
+		rates[i-1] = row
+	}
+	return rates, nil
+}
+
+// SparseRow is a row of the values extracted by ExtractSparse.
+type SparseRow struct {
+	// When is the number of timeunits since the epoch of the row.
+	When float64
+	// Values holds the value of each var that changed since the
+	// previous row. The first row holds the values of all vars.
+	Values map[string]float64
+}
+
+// ExtractSparse is the same as ExtractNumbers, but each returned row
+// only holds the values that changed since the previous row. Since
+// rows are converted to this form as they are extracted, the dense
+// rows are never all held in memory.
+func ExtractSparse(snaps []*Snapshot, timeunits time.Duration, from, to time.Time, vars []string) ([]SparseRow, error) {
+	e, start, err := newExtraction(snaps, timeunits, from, to, vars)
+	if err != nil {
+		return nil, err
+	}
+	var rows []SparseRow
+	var prev []float64
+	flush := func(lines [][]float64) {
+		for _, line := range lines {
+			row := SparseRow{When: line[0], Values: make(map[string]float64)}
+			for j, k := range vars {
+				if x := line[j+1]; prev == nil || math.Float64bits(x) != math.Float64bits(prev[j+1]) {
+					row.Values[k] = x
+				}
+			}
+			rows = append(rows, row)
+			prev = line
 		}
-		starts[k] = n
 	}
-	lastTS := float64(0)
-	ts := float64(from.UnixNano() / int64(timeunits))
-	var lines [][]float64
-	done := false
-	for i := minI + 1; i <= len(snaps); i++ {
-		vs := []float64{ts}
-		for _, k := range vars {
-			vs = append(vs, starts[k])
+	for i := start; i < len(snaps) && !e.done; i++ {
+		if err := e.step(i, snaps[i]); err != nil {
+			return nil, err
 		}
-		if ts == lastTS {
-			lines[len(lines)-1] = vs
-		} else {
-			lines = append(lines, vs)
+		// Only the last row can still be replaced by a later
+		// row with the same timestamp.
+		if n := len(e.lines); n > 1 {
+			flush(e.lines[:n-1])
+			e.lines = append(e.lines[:0], e.lines[n-1])
 		}
-		if done || i == len(snaps) {
-			break
+	}
+	flush(e.lines)
+	return rows, nil
+}
+
+// ExtractNumbersMulti performs an ExtractNumbers for each of the
+// (from, to) time ranges in a single forward scan over snaps. The
+// returned array holds the ExtractNumbers result for each range, in
+// the order of ranges.
+func ExtractNumbersMulti(snaps []*Snapshot, timeunits time.Duration, ranges [][2]time.Time, vars []string) ([][][]float64, error) {
+	es := make([]*extraction, len(ranges))
+	starts := make([]int, len(ranges))
+	order := make([]int, len(ranges))
+	for j, r := range ranges {
+		e, start, err := newExtraction(snaps, timeunits, r[0], r[1], vars)
+		if err != nil {
+			return nil, fmt.Errorf("range %d: %w", j, err)
 		}
-		lastTS = ts
-		s := snaps[i]
-		if s.When.Before(to) {
-			ts = float64(s.When.UnixNano() / int64(timeunits))
-		} else if tts := float64(to.UnixNano() / int64(timeunits)); ts == tts {
-			break
-		} else {
-			ts = tts
-			done = true
-			continue
+		es[j], starts[j], order[j] = e, start, j
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return starts[order[a]] < starts[order[b]]
+	})
+	var active []int
+	next := 0
+	for i := 0; i < len(snaps); i++ {
+		if len(active) == 0 {
+			if next == len(order) {
+				break
+			}
+			if s := starts[order[next]]; s > i {
+				i = s
+				if i >= len(snaps) {
+					break
+				}
+			}
 		}
-		for k, x := range s.Values.Detail {
-			v, err := AsNumber(x)
-			if err != nil {
-				return nil, fmt.Errorf("snapshot[%d][%q] = %v: %v", i, k, x, err)
+		for next < len(order) && starts[order[next]] <= i {
+			active = append(active, order[next])
+			next++
+		}
+		kept := active[:0]
+		for _, j := range active {
+			if err := es[j].step(i, snaps[i]); err != nil {
+				return nil, fmt.Errorf("range %d: %w", j, err)
+			}
+			if !es[j].done {
+				kept = append(kept, j)
 			}
-			starts[k] = v
 		}
+		active = kept
+	}
+	results := make([][][]float64, len(es))
+	for j, e := range es {
+		results[j] = e.lines
 	}
-	return lines, nil
+	return results, nil
 }
 
 // Sample holds an (Timestamp,X) value.
@@ -267,19 +1849,40 @@ type Sample struct {
 // Rate is a convenience function for determining the rate of change
 // of some variable, at v[1]. It tries to compute the gradient of the
 // two points around the center point, but fails over to a best guess
-// based on two or fewer samples.
+// based on two or fewer samples. The samples need not be in time
+// order, and samples with the same time are merged, keeping the
+// value of the last one provided, so the result is always finite.
+// The rate is per second, see RatePer for other units.
 func Rate(v ...Sample) float64 {
+	return RatePer(time.Second, v...)
+}
+
+// RatePer is the same as Rate, but the returned rate of change is per
+// unit of time, for example, per time.Minute.
+func RatePer(unit time.Duration, v ...Sample) float64 {
 	if len(v) <= 1 {
 		return 0
 	}
-	t0 := float64(v[0].When.UnixNano())
-	var t1, v1 float64
-	if len(v) == 2 {
-		t1 = float64(v[1].When.UnixNano())
-		v1 = v[1].Value
-	} else {
-		t1 = float64(v[2].When.UnixNano())
-		v1 = v[2].Value
+	v = append([]Sample(nil), v...)
+	sort.SliceStable(v, func(a, b int) bool {
+		return v[a].When.Before(v[b].When)
+	})
+	n := 0
+	for _, x := range v {
+		if n > 0 && x.When.Equal(v[n-1].When) {
+			v[n-1] = x
+			continue
+		}
+		v[n] = x
+		n++
+	}
+	v = v[:n]
+	if len(v) <= 1 {
+		return 0
+	}
+	v1 := v[1]
+	if len(v) > 2 {
+		v1 = v[2]
 	}
-	return (v1 - v[0].Value) / (t1 - t0) * float64(time.Second/time.Nanosecond)
+	return (v1.Value - v[0].Value) * float64(unit) / float64(v1.When.Sub(v[0].When))
 }
@@ -2,7 +2,10 @@ package vars
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"testing"
 	"time"
@@ -46,6 +49,103 @@ func TestGetNumber(t *testing.T) {
 	}
 }
 
+func TestSetRounded(t *testing.T) {
+	m := New()
+	a, b := 0.1, 0.2
+	vs := []struct {
+		v        float64
+		decimals int
+		want     float64
+	}{
+		{a + b, 3, 0.3},
+		{2.0 / 3, 2, 0.67},
+		{1234.5, -2, 1200},
+		{math.Inf(1), 2, math.Inf(1)},
+		{1e300, 10, 1e300},
+	}
+	for i, v := range vs {
+		if err := m.SetRounded("x", v.v, v.decimals); err != nil {
+			t.Fatalf("[%d] SetRounded failed: %v", i, err)
+		}
+		if got := m.Get("x"); got != v.want {
+			t.Errorf("[%d] got=%v, want=%v", i, got, v.want)
+		}
+	}
+}
+
+func TestGetNumberState(t *testing.T) {
+	m := New()
+	m.Set("n", 2)
+	m.Set("s", "error text")
+	vs := []struct {
+		k     string
+		n     float64
+		state NumState
+	}{
+		{"n", 2, NumOK},
+		{"s", 0, NumNotNumber},
+		{"x", 0, NumMissing},
+	}
+	for _, v := range vs {
+		if n, state := m.GetNumberState(v.k); n != v.n || state != v.state {
+			t.Errorf("%q: got=%g, %v, want=%g, %v", v.k, n, state, v.n, v.state)
+		}
+	}
+}
+
+func TestMustGetNumber(t *testing.T) {
+	m := New()
+	m.Set("n", 7)
+	m.Set("s", "x")
+	if got := m.MustGetNumber("n"); got != 7 {
+		t.Errorf("got=%g, want=7", got)
+	}
+	for _, k := range []string{"s", "missing"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: no panic", k)
+				}
+			}()
+			m.MustGetNumber(k)
+		}()
+	}
+}
+
+func TestSumKeys(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", 2.5)
+	m.Set("s", "x")
+	if err := m.SumKeys("total", "a", "b"); err != nil || m.Get("total") != 3.5 {
+		t.Errorf("got=%v, %v, want=3.5", m.Get("total"), err)
+	}
+	if err := m.SumKeys("total", "a", "s"); !errors.Is(err, ErrNotNumber) {
+		t.Errorf("got err=%v, want=%v", err, ErrNotNumber)
+	}
+	if err := m.SumKeys("total", "a", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got err=%v, want=%v", err, ErrNotFound)
+	}
+	if m.Get("total") != 3.5 {
+		t.Errorf("failed sum changed total: got=%v", m.Get("total"))
+	}
+	if err := m.SumNumericKeys("total", "a", "s", "missing"); err != nil || m.Get("total") != 1.0 {
+		t.Errorf("got=%v, %v, want=1", m.Get("total"), err)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	m := New()
+	m.Set("n", int64(3))
+	m.Set("s", "x")
+	if v, n, ok := m.Peek("n"); v != int64(3) || n != 3 || !ok {
+		t.Errorf("number: got=%v, %g, %v", v, n, ok)
+	}
+	if v, _, ok := m.Peek("s"); v != "x" || ok {
+		t.Errorf("string: got=%v, %v", v, ok)
+	}
+}
+
 func TestAdd(t *testing.T) {
 	m := New()
 	m.Set("a", 4)
@@ -173,6 +273,27 @@ func TestTrim(t *testing.T) {
 	}
 }
 
+func TestTrimFunc(t *testing.T) {
+	base := time.Unix(100, 0)
+	var dts []time.Duration
+	for i := 0; i < 6; i++ {
+		dts = append(dts, time.Duration(i)*time.Second)
+	}
+	snaps := testSeries(base, dts, []interface{}{1.0, 1.0004, 1.0008, 1.0012, 1.5, 1.5})
+	shrink := TrimFunc(snaps, func(a, b interface{}) bool {
+		x, _ := AsNumber(a)
+		y, _ := AsNumber(b)
+		return math.Abs(x-y) < 1e-3
+	})
+	var got []string
+	for _, s := range shrink {
+		got = append(got, fmt.Sprint(s.Values.Get("x")))
+	}
+	if want := "1,1.0012,1.5,1.5"; strings.Join(got, ",") != want {
+		t.Errorf("got=%q, want=%q", strings.Join(got, ","), want)
+	}
+}
+
 func TestRate(t *testing.T) {
 	samples := []struct {
 		dt time.Duration
@@ -193,3 +314,1070 @@ func TestRate(t *testing.T) {
 		}
 	}
 }
+
+func TestNonFinite(t *testing.T) {
+	m := New()
+	m.Set("a", 1.5)
+	m.Set("b", math.Inf(1))
+	m.Set("c", math.NaN())
+	m.Set("d", float32(math.Inf(-1)))
+	vs := []struct {
+		policy NonFinite
+		rows   []string
+		err    bool
+	}{
+		{policy: NonFiniteAsIs, rows: []string{"a | 1.5", "b | +Inf", "c | NaN", "d | -Inf"}},
+		{policy: NonFiniteSkip, rows: []string{"a | 1.5"}},
+		{policy: NonFiniteZero, rows: []string{"a | 1.5", "b | 0", "c | 0", "d | 0"}},
+		{policy: NonFiniteError, err: true},
+	}
+	for i, v := range vs {
+		d, err := m.DumpMDTableWithOptions(&WriteOptions{NonFinite: v.policy})
+		if v.err {
+			if !errors.Is(err, ErrNonFinite) {
+				t.Errorf("[%d] got err=%v, want=%v", i, err, ErrNonFinite)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", i, err)
+			continue
+		}
+		lines := strings.Split(strings.TrimSuffix(string(d), "\n"), "\n")[2:]
+		if got, want := strings.Join(lines, ","), strings.Join(v.rows, ","); got != want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, want)
+		}
+	}
+
+	vs2 := New()
+	vs2.Set("x", 1.0)
+	snaps := []*Snapshot{vs2.Snap()}
+	time.Sleep(2 * time.Millisecond)
+	vs2.Set("x", math.Inf(-1))
+	snaps = append(snaps, vs2.Snap())
+	time.Sleep(2 * time.Millisecond)
+	vs2.Set("x", 2.0)
+	snaps = append(snaps, vs2.Snap())
+	from, to := snaps[0].When, snaps[2].When.Add(time.Millisecond)
+	all, err := ExtractNumbersWithOptions(snaps, time.Millisecond, from, to, []string{"x"}, nil)
+	if err != nil {
+		t.Fatalf("extraction failed: %v", err)
+	}
+	kept, err := ExtractNumbersWithOptions(snaps, time.Millisecond, from, to, []string{"x"}, &WriteOptions{NonFinite: NonFiniteSkip})
+	if err != nil {
+		t.Fatalf("skipping extraction failed: %v", err)
+	}
+	if got, want := len(kept), len(all)-1; got != want {
+		t.Errorf("skipped rows: got=%d, want=%d", got, want)
+	}
+	if _, err := ExtractNumbersWithOptions(snaps, time.Millisecond, from, to, []string{"x"}, &WriteOptions{NonFinite: NonFiniteError}); !errors.Is(err, ErrNonFinite) {
+		t.Errorf("got err=%v, want=%v", err, ErrNonFinite)
+	}
+}
+
+func TestExtractNumbersErrors(t *testing.T) {
+	m := New()
+	m.Set("n", 1)
+	m.Set("s", "text")
+	snaps := []*Snapshot{m.Snap()}
+	from := snaps[0].When
+	to := from.Add(time.Second)
+	if _, err := ExtractNumbers(snaps, time.Millisecond, from, to, []string{"missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing: got err=%v, want=%v", err, ErrNotFound)
+	}
+	if _, err := ExtractNumbers(snaps, time.Millisecond, from, to, []string{"s"}); !errors.Is(err, ErrNotNumber) {
+		t.Errorf("string: got err=%v, want=%v", err, ErrNotNumber)
+	}
+	if _, err := ExtractNumbersMulti(snaps, time.Millisecond, [][2]time.Time{{from, to}}, []string{"missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("multi: got err=%v, want=%v", err, ErrNotFound)
+	}
+}
+
+func TestExtractNumbersMulti(t *testing.T) {
+	base := time.Now()
+	var snaps []*Snapshot
+	vs := New()
+	for i := 0; i < 50; i++ {
+		vs.Set("a", i)
+		vs.Set("b", 2*i)
+		s := vs.Snap()
+		s.When = base.Add(time.Duration(i) * time.Millisecond)
+		snaps = append(snaps, s)
+	}
+	ranges := [][2]time.Time{
+		{base.Add(30 * time.Millisecond), base.Add(45 * time.Millisecond)},
+		{base, base.Add(10 * time.Millisecond)},
+		{base.Add(5 * time.Millisecond), base.Add(35 * time.Millisecond)},
+		{base.Add(40 * time.Millisecond), base.Add(time.Second)},
+	}
+	ks := []string{"a", "b"}
+	multi, err := ExtractNumbersMulti(snaps, time.Millisecond, ranges, ks)
+	if err != nil {
+		t.Fatalf("ExtractNumbersMulti failed: %v", err)
+	}
+	if got, want := len(multi), len(ranges); got != want {
+		t.Fatalf("bad number of results: got=%d, want=%d", got, want)
+	}
+	for i, r := range ranges {
+		nums, err := ExtractNumbers(snaps, time.Millisecond, r[0], r[1], ks)
+		if err != nil {
+			t.Fatalf("[%d] ExtractNumbers failed: %v", i, err)
+		}
+		if got, want := fmt.Sprint(multi[i]), fmt.Sprint(nums); got != want {
+			t.Errorf("[%d] got=%v, want=%v", i, got, want)
+		}
+	}
+	if _, err := ExtractNumbersMulti(snaps, time.Millisecond, [][2]time.Time{{base.Add(-time.Second), base}}, ks); err == nil {
+		t.Error("range before the first snapshot succeeded")
+	}
+}
+
+func TestInferTrimmed(t *testing.T) {
+	base := time.Unix(100, 0)
+	var snaps []*Snapshot
+	m := New()
+	for i := 0; i < 10; i++ {
+		m.Set("count", i)
+		if i < 3 {
+			m.Set("stable", 42)
+		}
+		s := m.Snap()
+		s.When = base.Add(time.Duration(i) * time.Second)
+		snaps = append(snaps, s)
+	}
+	// The latest snapshots no longer hold "stable" at all.
+	for _, s := range snaps[5:] {
+		delete(s.Values.Detail, "stable")
+	}
+	snaps = Trim(snaps)
+	at := base.Add(7500 * time.Millisecond)
+	index, v, err := Infer(snaps, at, "stable")
+	if err != nil || v != 42 {
+		t.Fatalf("got=%v, %v, want=42", v, err)
+	}
+	if !snaps[index].When.Equal(base) {
+		t.Errorf("got recorded time=%v, want=%v", snaps[index].When, base)
+	}
+	if when, _, err := InferWhen(snaps, at, "stable"); err != nil || !when.Equal(base) {
+		t.Errorf("InferWhen: got=%v, %v, want=%v", when, err, base)
+	}
+	nums, err := ExtractNumbers(snaps, time.Second, at, base.Add(9*time.Second), []string{"count", "stable"})
+	if err != nil {
+		t.Fatalf("ExtractNumbers failed: %v", err)
+	}
+	if got, want := fmt.Sprint(nums), "[[107 7 42] [108 8 42] [109 8 42]]"; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
+
+func TestInferRange(t *testing.T) {
+	base := time.Unix(100, 0)
+	var dts []time.Duration
+	for i := 0; i < 5; i++ {
+		dts = append(dts, time.Duration(i)*time.Second)
+	}
+	snaps := testSeries(base, dts, []interface{}{1, 1, 2, 2, 5})
+	vs := []struct {
+		at         time.Duration
+		start, end time.Duration
+		v          interface{}
+	}{
+		{1500 * time.Millisecond, 0, 2 * time.Second, 1},
+		{2500 * time.Millisecond, 2 * time.Second, 4 * time.Second, 2},
+		{time.Minute, 4 * time.Second, 4 * time.Second, 5},
+	}
+	for i, v := range vs {
+		start, end, x, err := InferRange(snaps, base.Add(v.at), "x")
+		if err != nil {
+			t.Fatalf("[%d] InferRange failed: %v", i, err)
+		}
+		if x != v.v || !start.Equal(base.Add(v.start)) || !end.Equal(base.Add(v.end)) {
+			t.Errorf("[%d] got=[%v, %v) %v, want=[%v, %v) %v", i, start.Sub(base), end.Sub(base), x, v.start, v.end, v.v)
+		}
+	}
+	if _, _, _, err := InferRange(snaps, base.Add(-time.Second), "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got err=%v, want=%v", err, ErrNotFound)
+	}
+}
+
+func TestExtractRates(t *testing.T) {
+	base := time.Unix(1000, 0)
+	var snaps []*Snapshot
+	vs := New()
+	for i, c := range []int{0, 10, 20, 5, 15} {
+		vs.Set("c", c)
+		vs.Set("g", i)
+		s := vs.Snap()
+		s.When = base.Add(time.Duration(i) * time.Millisecond)
+		snaps = append(snaps, s)
+	}
+	rates, err := ExtractRates(snaps, time.Millisecond, base, base.Add(10*time.Millisecond), []string{"c", "g"}, map[string]bool{"c": true})
+	if err != nil {
+		t.Fatalf("ExtractRates failed: %v", err)
+	}
+	want := "[[1.000001e+06 10 1] [1.000002e+06 10 2] [1.000003e+06 5 3] [1.000004e+06 10 4]]"
+	if got := fmt.Sprint(rates); got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
+
+func TestDumpMDTableWithRate(t *testing.T) {
+	m := New()
+	m.Set("count", 10)
+	m.Set("name", "x")
+	prev := m.Snap()
+	prev.When = prev.When.Add(-2 * time.Second)
+	m.Set("count", 20)
+	m.Set("new", 1)
+	lines := strings.Split(string(m.DumpMDTableWithRate(prev)), "\n")
+	if !strings.HasSuffix(lines[0], " | rate/s") {
+		t.Errorf("bad header: %q", lines[0])
+	}
+	var rate float64
+	if _, err := fmt.Sscanf(lines[2], "count | 20 | %g", &rate); err != nil || rate > 5 || rate < 4.9 {
+		t.Errorf("bad count row: %q", lines[2])
+	}
+	if got, want := strings.Join(lines[3:5], ","), "name | x | ,new | 1 | "; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestExtractSparse(t *testing.T) {
+	base := time.Unix(1000, 0)
+	var snaps []*Snapshot
+	vs := New()
+	for i := 0; i < 10; i++ {
+		vs.Set("a", i)
+		vs.Set("b", i/4)
+		s := vs.Snap()
+		s.When = base.Add(time.Duration(i) * time.Millisecond)
+		snaps = append(snaps, s)
+	}
+	ks := []string{"a", "b"}
+	from, to := base.Add(time.Millisecond), base.Add(8*time.Millisecond)
+	dense, err := ExtractNumbers(snaps, time.Millisecond, from, to, ks)
+	if err != nil {
+		t.Fatalf("ExtractNumbers failed: %v", err)
+	}
+	sparse, err := ExtractSparse(snaps, time.Millisecond, from, to, ks)
+	if err != nil {
+		t.Fatalf("ExtractSparse failed: %v", err)
+	}
+	if len(sparse) != len(dense) {
+		t.Fatalf("got %d rows, want %d", len(sparse), len(dense))
+	}
+	if got := len(sparse[0].Values); got != 2 {
+		t.Errorf("first row holds %d values, want 2", got)
+	}
+	values := make(map[string]float64)
+	changes := 0
+	for i, row := range sparse {
+		changes += len(row.Values)
+		for k, v := range row.Values {
+			values[k] = v
+		}
+		if got, want := fmt.Sprint(row.When, " ", values["a"], " ", values["b"]), fmt.Sprint(dense[i][0], " ", dense[i][1], " ", dense[i][2]); got != want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, want)
+		}
+	}
+	if changes >= 2*len(dense) {
+		t.Errorf("sparse rows hold %d values, not fewer than %d", changes, 2*len(dense))
+	}
+}
+
+func TestSetFormatter(t *testing.T) {
+	m := New()
+	m.Set("bytes", 1<<30)
+	m.Set("plain", 7)
+	if err := m.SetFormatter("bytes", func(v interface{}) string {
+		n, _ := AsNumber(v)
+		return fmt.Sprintf("%.1f GiB", n/(1<<30))
+	}); err != nil {
+		t.Fatalf("failed to set formatter: %v", err)
+	}
+	lines := strings.Split(string(m.DumpMDTable()), "\n")
+	if got, want := strings.Join(lines[2:4], ","), "bytes | 1.0 GiB,plain | 7"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+	if n, err := m.GetNumber("bytes"); err != nil || n != 1<<30 {
+		t.Errorf("formatter changed value: got=%g, %v", n, err)
+	}
+	m.SetFormatter("bytes", nil)
+	lines = strings.Split(string(m.DumpMDTable()), "\n")
+	if got, want := lines[2], fmt.Sprint("bytes | ", 1<<30); got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestAddClamped(t *testing.T) {
+	m := New()
+	m.Set("g", 10)
+	if got := m.AddClamped("g", 3, 5); got != 3 {
+		t.Errorf("small step: applied=%g, want=3", got)
+	}
+	if got := m.AddClamped("g", 1e9, 5); got != 5 {
+		t.Errorf("large step: applied=%g, want=5", got)
+	}
+	if got := m.AddClamped("g", -100, 5); got != -5 {
+		t.Errorf("negative step: applied=%g, want=-5", got)
+	}
+	if got := m.Get("g"); got != 13 {
+		t.Errorf("got=%v, want=13", got)
+	}
+}
+
+func TestAddWithLimits(t *testing.T) {
+	m := New()
+	for i := 0; i < 5; i++ {
+		m.AddWithCap("inflight", 1, 3)
+	}
+	if v, err := m.GetNumber("inflight"); err != nil || v != 3 {
+		t.Errorf("capped: got=%g, %v, want=3", v, err)
+	}
+	for i := 0; i < 5; i++ {
+		m.AddWithFloor("inflight", -1, 0)
+	}
+	if v, err := m.GetNumber("inflight"); err != nil || v != 0 {
+		t.Errorf("floored: got=%g, %v, want=0", v, err)
+	}
+	m.Set("label", "x")
+	m.AddWithCap("label", 10, 5)
+	if v, err := m.GetNumber("label"); err != nil || v != 5 {
+		t.Errorf("replaced: got=%g, %v, want=5", v, err)
+	}
+}
+
+func TestTimedValue(t *testing.T) {
+	m := New()
+	login := time.Now().Add(-time.Hour)
+	m.SetTimestamped("logins", 42, login)
+	if v, err := m.GetNumber("logins"); err != nil || v != 42 {
+		t.Errorf("GetNumber: got=%g, %v, want=42", v, err)
+	}
+	snaps := []*Snapshot{m.Snap()}
+	when, v, err := InferWhen(snaps, time.Now(), "logins")
+	if err != nil {
+		t.Fatalf("InferWhen failed: %v", err)
+	}
+	if !when.Equal(login) || v != 42 {
+		t.Errorf("InferWhen: got=%v, %v, want=%v, 42", when, v, login)
+	}
+	m.Set("plain", 1)
+	snaps = append(snaps, m.Snap())
+	if when, _, err := InferWhen(snaps, time.Now(), "plain"); err != nil || !when.Equal(snaps[1].When) {
+		t.Errorf("InferWhen plain: got=%v, %v, want=%v", when, err, snaps[1].When)
+	}
+	lines := strings.Split(string(m.DumpMDTable()), "\n")
+	if got := lines[2]; !strings.HasPrefix(got, "logins | 42 (age 1h0m0") {
+		t.Errorf("unexpected row: %q", got)
+	}
+}
+
+func TestResetOnRead(t *testing.T) {
+	m := New()
+	m.Set("count", 3)
+	m.Set("ratio", 0.5)
+	m.SetMeta("ratio", Meta{Kind: KindGauge})
+	m.Set("name", "x")
+	inflight := m.Gauge("inflight")
+	inflight.Inc()
+	m.AddCollector(CollectorFunc(func(m *Metrics) error {
+		return m.setCollected("collected", 7, Meta{Kind: KindCounter})
+	}))
+	opts := &WriteOptions{Counters: ResetOnRead}
+	first, err := m.DumpMDTableWithOptions(opts)
+	if err != nil {
+		t.Fatalf("first dump failed: %v", err)
+	}
+	if got, want := strings.Join(strings.Split(string(first), "\n")[2:7], ","), "collected | 7,count | 3,inflight | 1,name | x,ratio | 0.5"; got != want {
+		t.Errorf("first dump: got=%q, want=%q", got, want)
+	}
+	if v := m.Get("count"); v != 0 {
+		t.Errorf("count not reset to int zero: got=%#v", v)
+	}
+	inflight.Dec()
+	if got := inflight.Value(); got != 0 {
+		t.Errorf("gauge reset: got=%g, want=0", got)
+	}
+	if got := m.Get("collected"); got != 7 {
+		t.Errorf("collected metric reset: got=%#v", got)
+	}
+	m.Add("count", 2)
+	second, err := m.DumpMDTableWithOptions(opts)
+	if err != nil {
+		t.Fatalf("second dump failed: %v", err)
+	}
+	if got, want := strings.Join(strings.Split(string(second), "\n")[2:7], ","), "collected | 7,count | 2,inflight | 0,name | x,ratio | 0.5"; got != want {
+		t.Errorf("second dump: got=%q, want=%q", got, want)
+	}
+}
+
+func TestAddDuration(t *testing.T) {
+	m := New()
+	m.Set("label", "x")
+	m.AddDuration("elapsed", time.Second)
+	m.AddDuration("elapsed", 500*time.Millisecond)
+	m.AddDuration("label", time.Millisecond)
+	if d, err := m.GetDuration("elapsed"); err != nil || d != 1500*time.Millisecond {
+		t.Errorf("elapsed: got=%v, %v, want=1.5s", d, err)
+	}
+	if d, err := m.GetDuration("label"); err != nil || d != time.Millisecond {
+		t.Errorf("label: got=%v, %v, want=1ms", d, err)
+	}
+	m.Set("count", 3)
+	if _, err := m.GetDuration("count"); err != ErrNotDuration {
+		t.Errorf("count: got err=%v, want=%v", err, ErrNotDuration)
+	}
+	if n, err := m.GetNumber("elapsed"); err != nil || n != 1.5e9 {
+		t.Errorf("elapsed as number: got=%g, %v, want=1.5e9", n, err)
+	}
+	if got, want := strings.Split(string(m.DumpMDTable()), "\n")[3], "elapsed | 1.5s"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	m := New()
+	m.Set("a", 5)
+	m.Touch("a")
+	m.Touch("b")
+	if got := m.Get("a"); got != 5 {
+		t.Errorf("touched existing: got=%v, want=5", got)
+	}
+	if v, err := m.GetNumber("b"); err != nil || v != 0 {
+		t.Errorf("touched absent: got=%g, %v, want=0", v, err)
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	m := New()
+	a, b := 0.1, 0.2
+	m.Set("ratio", a+b)
+	m.Set("count", 12345)
+	m.Set("third", float32(1)/3)
+	vs := []struct {
+		precision int
+		rows      string
+	}{
+		{precision: 0, rows: "count | 12345,ratio | 0.30000000000000004,third | 0.33333334"},
+		{precision: 3, rows: "count | 12345,ratio | 0.3,third | 0.333"},
+	}
+	for i, v := range vs {
+		d, err := m.DumpMDTableWithOptions(&WriteOptions{Precision: v.precision})
+		if err != nil {
+			t.Fatalf("[%d] dump failed: %v", i, err)
+		}
+		if got := strings.Join(strings.Split(string(d), "\n")[2:5], ","); got != v.rows {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.rows)
+		}
+	}
+}
+
+func TestIncDec(t *testing.T) {
+	m := New()
+	m.Inc("a")
+	m.Inc("a")
+	m.Inc("a")
+	m.Dec("a")
+	m.Dec("b")
+	if v, err := m.GetNumber("a"); err != nil || v != 2 {
+		t.Errorf("a: got=%g, %v, want=2", v, err)
+	}
+	if v, err := m.GetNumber("b"); err != nil || v != -1 {
+		t.Errorf("b: got=%g, %v, want=-1", v, err)
+	}
+}
+
+func TestAsInt64(t *testing.T) {
+	big := uint64(1)<<53 + 1
+	vs := []struct {
+		v    interface{}
+		n    int64
+		fits bool
+	}{
+		{v: 7, n: 7, fits: true},
+		{v: int32(-3), n: -3, fits: true},
+		{v: big, n: int64(big), fits: true},
+		{v: uint64(math.MaxUint64), fits: false},
+		{v: 2.0, fits: false},
+		{v: time.Second, n: int64(time.Second), fits: true},
+		{v: "8", fits: false},
+	}
+	for i, v := range vs {
+		n, ok := AsInt64(v.v)
+		if ok != v.fits || n != v.n {
+			t.Errorf("[%d] AsInt64(%v): got=%d, %v, want=%d, %v", i, v.v, n, ok, v.n, v.fits)
+		}
+	}
+	if f, _ := AsNumber(big); uint64(f) == big {
+		t.Errorf("expected float64 precision loss for %d", big)
+	}
+}
+
+func TestAge(t *testing.T) {
+	s := New().Snap()
+	if got := s.Age(s.When.Add(time.Minute)); got != time.Minute {
+		t.Errorf("got=%v, want=1m", got)
+	}
+	if got := s.Age(s.When.Add(-time.Second)); got != -time.Second {
+		t.Errorf("future snapshot: got=%v, want=-1s", got)
+	}
+}
+
+func TestDrainInto(t *testing.T) {
+	m := New()
+	m.Set("count", 4)
+	m.Set("name", "x")
+	dst := map[string]interface{}{"stale": 1}
+	m.DrainInto(dst)
+	if got, want := fmt.Sprint(dst), "map[count:4 name:x stale:1]"; got != want {
+		t.Errorf("drained: got=%s, want=%s", got, want)
+	}
+	if got, want := fmt.Sprint(m.Detail), "map[count:0 name:x]"; got != want {
+		t.Errorf("remaining: got=%s, want=%s", got, want)
+	}
+}
+
+func TestDrainIntoCopies(t *testing.T) {
+	m := New()
+	m.Observe("latency", 1)
+	m.Summary("size", 0.5).Observe(3)
+	m.Set("count", 4)
+	old := time.Now().Add(-time.Hour)
+	m.backdate("count", old)
+	dst := make(map[string]interface{})
+	m.DrainInto(dst)
+	h, ok := dst["latency"].(*Histogram)
+	if !ok || h == m.Get("latency") || h.Count() != 1 {
+		t.Errorf("histogram not copied: got=%#v", dst["latency"])
+	}
+	if _, ok := dst["size"]; ok || dst["size.count"] == nil {
+		t.Errorf("summary not expanded: got=%v", dst)
+	}
+	if updated, _ := m.LastUpdated("count"); !updated.Equal(old) {
+		t.Errorf("drain updated count: got=%v, want=%v", updated, old)
+	}
+	m.Prune(time.Minute)
+	if _, ok := m.Detail["count"]; ok {
+		t.Error("drained metric not pruned")
+	}
+}
+
+func TestSetOnce(t *testing.T) {
+	m := New()
+	if err := m.SetOnce("version", "1.0"); err != nil {
+		t.Fatalf("first SetOnce failed: %v", err)
+	}
+	if err := m.SetOnce("version", "2.0"); err != ErrAlreadySet {
+		t.Errorf("second SetOnce: got=%v, want=%v", err, ErrAlreadySet)
+	}
+	if got := m.Get("version"); got != "1.0" {
+		t.Errorf("value overwritten: got=%v, want=1.0", got)
+	}
+}
+
+func TestSnapFunc(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", 5)
+	m.Set("c", "text")
+	s := m.SnapFunc(func(k string, v interface{}) bool {
+		n, err := AsNumber(v)
+		return err == nil && n > 2 && m.Get(k) != nil
+	})
+	if got, want := s.Values.String(), `{"b":5}`; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
+
+func TestInRange(t *testing.T) {
+	m := New()
+	m.Set("low", 10)
+	m.Set("edge", 90)
+	m.Set("high", 95.5)
+	m.Set("over", 101)
+	m.Set("s", "x")
+	if got, want := m.InRange(90, 100).String(), `{"edge":90,"high":95.5}`; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
+
+func TestSnapAligned(t *testing.T) {
+	m := New()
+	m.Set("x", 1)
+	s := m.SnapAligned(time.Minute)
+	after := time.Now()
+	if s.When.UnixNano()%int64(time.Minute) != 0 {
+		t.Errorf("unaligned time: %v", s.When)
+	}
+	if s.When.After(after) || after.Sub(s.When) >= 2*time.Minute {
+		t.Errorf("time %v is not the interval before %v", s.When, after)
+	}
+	if got := s.Get("x"); got != 1 {
+		t.Errorf("got=%v, want=1", got)
+	}
+}
+
+func TestSnapAll(t *testing.T) {
+	a, b := New(), New()
+	a.Set("x", 1)
+	b.Set("x", 2)
+	snaps := SnapAll(a, b)
+	if len(snaps) != 2 {
+		t.Fatalf("got %d snapshots, want 2", len(snaps))
+	}
+	if !snaps[0].When.Equal(snaps[1].When) {
+		t.Errorf("times differ: %v vs %v", snaps[0].When, snaps[1].When)
+	}
+	if got := snaps[1].Values.Get("x"); got != 2 {
+		t.Errorf("got=%v, want=2", got)
+	}
+}
+
+func TestDiffReport(t *testing.T) {
+	a, b := New(), New()
+	a.Set("same", 1)
+	b.Set("same", 1)
+	a.Set("changed", 1)
+	b.Set("changed", 2)
+	a.Set("retyped", 1)
+	b.Set("retyped", 1.0)
+	a.Set("removed", "x")
+	b.Set("added", true)
+	want := "+ added = true\n~ changed: 1 -> 2\n- removed = x\n~ retyped: 1 -> 1\n"
+	if got := DiffReport(a, b); got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+	if got := DiffReport(a, a); got != "" {
+		t.Errorf("no change: got=%q", got)
+	}
+	if got, want := DiffReport(nil, b), "+ added = true\n+ changed = 2\n+ retyped = 1\n+ same = 1\n"; got != want {
+		t.Errorf("nil: got=%q, want=%q", got, want)
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	m := New()
+	values := []interface{}{int32(-3), int64(1 << 60), uint(7), float32(1.5), 2.5, time.Second, "s", true}
+	for i, v := range values {
+		m.Set(fmt.Sprint(i), v)
+	}
+	dst := New()
+	dst.Set("other", 1)
+	if err := m.CopyTo(dst); err != nil {
+		t.Fatalf("CopyTo failed: %v", err)
+	}
+	for i, v := range values {
+		if got := dst.Get(fmt.Sprint(i)); got != v {
+			t.Errorf("[%d] got=%v (%T), want=%v (%T)", i, got, got, v, v)
+		}
+	}
+	if dst.Get("other") != 1 {
+		t.Error("CopyTo changed other metrics")
+	}
+	if err := m.CopyTo(m); err != nil {
+		t.Errorf("copy to self failed: %v", err)
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	m := New()
+	m.Set("a", 10)
+	m.Set("b", 1.5)
+	prev := m.Snap()
+	m.Add("a", 5)
+	m.Add("b", 2)
+	m.Set("c", 4)
+	cur := m.Snap()
+	d, err := cur.Sub(prev)
+	if err != nil {
+		t.Fatalf("Sub failed: %v", err)
+	}
+	r := New()
+	prev.Values.CopyTo(r)
+	if err := r.ApplyDelta(d); err != nil {
+		t.Fatalf("ApplyDelta failed: %v", err)
+	}
+	if got, want := r.String(), cur.Values.String(); got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	if got := r.Get("a"); got != 15 {
+		t.Errorf("type changed: got=%v (%T), want=15 (int)", got, got)
+	}
+	d.Values.Set("name", "x")
+	r.ApplyDelta(d)
+	if got := r.Get("name"); got != "x" {
+		t.Errorf("non-numerical: got=%v, want=x", got)
+	}
+}
+
+func TestSnapshotGet(t *testing.T) {
+	m := New()
+	m.Set("n", 2)
+	m.Set("s", "x")
+	s := m.Snap()
+	m.Set("n", 3)
+	if got := s.Get("n"); got != 2 {
+		t.Errorf("got=%v, want=2", got)
+	}
+	if n, err := s.GetNumber("n"); err != nil || n != 2 {
+		t.Errorf("got=%g, %v, want=2", n, err)
+	}
+	if _, err := s.GetNumber("s"); !errors.Is(err, ErrNotNumber) {
+		t.Errorf("got err=%v, want=%v", err, ErrNotNumber)
+	}
+}
+
+func TestSnapshotSub(t *testing.T) {
+	m := New()
+	m.Set("a", 5)
+	m.Set("b", "label")
+	prev := m.Snap()
+	m.Add("a", 3)
+	m.Set("c", 2.5)
+	s := m.Snap()
+	d, err := s.Sub(prev)
+	if err != nil {
+		t.Fatalf("Sub failed: %v", err)
+	}
+	if got, want := fmt.Sprint(d.Values.Detail), "map[a:3 c:2.5]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+	if !d.When.Equal(s.When) {
+		t.Errorf("delta time: got=%v, want=%v", d.When, s.When)
+	}
+	m.Set("b", 1)
+	if _, err := m.Snap().Sub(prev); !errors.Is(err, ErrNotNumber) {
+		t.Errorf("got err=%v, want=%v", err, ErrNotNumber)
+	}
+}
+
+func TestWaitForValue(t *testing.T) {
+	m := New()
+	go func() {
+		for i := 0; i < 100; i++ {
+			m.Inc("requests")
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.WaitForValue(ctx, "requests", func(v interface{}) bool {
+		n, err := AsNumber(v)
+		return err == nil && n == 100
+	}); err != nil {
+		t.Fatalf("WaitForValue failed: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.WaitForValue(ctx, "requests", func(v interface{}) bool {
+		return v == nil
+	}); err != context.DeadlineExceeded {
+		t.Errorf("got err=%v, want=%v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	m := New()
+	before := time.Now()
+	m.Set("old", 1)
+	m.Set("new", 2)
+	m.Detail["direct"] = 3
+	when, ok := m.LastUpdated("new")
+	if !ok || when.Before(before) {
+		t.Errorf("LastUpdated: got=%v, %v, want>=%v", when, ok, before)
+	}
+	if _, ok := m.LastUpdated("direct"); ok {
+		t.Error("unexpected LastUpdated for a direct value")
+	}
+	m.backdate("old", time.Now().Add(-time.Hour))
+	m.Prune(time.Minute)
+	if got, want := strings.Join(m.Keys(), ","), "direct,new"; got != want {
+		t.Errorf("after pruning: got=%q, want=%q", got, want)
+	}
+	if _, ok := m.LastUpdated("old"); ok {
+		t.Error("LastUpdated survived pruning")
+	}
+}
+
+func TestValuesHash(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("b", "x")
+	s1 := m.Snap()
+	s2 := m.Snap()
+	if s1.ValuesHash() != s2.ValuesHash() {
+		t.Error("identical snapshots hash differently")
+	}
+	m.Set("a", "1")
+	if s3 := m.Snap(); s3.ValuesHash() == s1.ValuesHash() {
+		t.Error("string and int values hash the same")
+	}
+	m.Set("a", 1)
+	m.Set("ab", "")
+	m.Set("b", "x")
+	if s4 := m.Snap(); s4.ValuesHash() == s1.ValuesHash() {
+		t.Error("extra key not reflected in hash")
+	}
+}
+
+func TestRatePer(t *testing.T) {
+	now := time.Now()
+	pts := []Sample{{When: now, Value: 1}, {When: now.Add(2 * time.Second), Value: 3}}
+	if got := RatePer(time.Minute, pts...); got != 60 {
+		t.Errorf("per minute: got=%f, want=60", got)
+	}
+	if got, want := RatePer(time.Second, pts...), Rate(pts...); got != want {
+		t.Errorf("per second: got=%f, want=%f", got, want)
+	}
+}
+
+func TestAddTypes(t *testing.T) {
+	vs := []struct {
+		was  interface{}
+		n    float64
+		want interface{}
+	}{
+		{was: nil, n: 2, want: 2.0},
+		{was: "x", n: 2, want: 2.0},
+		{was: 4, n: 1, want: 5},
+		{was: 4, n: 0.5, want: 4.5},
+		{was: int32(math.MaxInt32), n: 1, want: float64(math.MaxInt32) + 1},
+		{was: int64(1) << 60, n: 1, want: int64(1)<<60 + 1},
+		{was: uint(3), n: -1, want: uint(2)},
+		{was: uint32(0), n: -1, want: -1.0},
+		{was: uint64(7), n: 1, want: uint64(8)},
+		{was: float32(1.5), n: 1, want: float32(2.5)},
+		{was: 1.5, n: 1, want: 2.5},
+		{was: time.Second, n: 1, want: time.Second + 1},
+		{was: TimedValue{When: time.Now(), V: 3}, n: 1, want: 4},
+	}
+	for i, v := range vs {
+		m := New()
+		if v.was != nil {
+			m.Set("x", v.was)
+		}
+		m.Add("x", v.n)
+		if got := m.Get("x"); got != v.want {
+			t.Errorf("[%d] %#v + %g: got=%#v, want=%#v", i, v.was, v.n, got, v.want)
+		}
+	}
+
+	m := New()
+	m.Set("n", 9)
+	m.AddWithCap("n", 5, 10)
+	if got := m.Get("n"); got != 10 {
+		t.Errorf("capped int: got=%#v, want=10", got)
+	}
+	m.AddWithCap("n", 1, 10.5)
+	if got := m.Get("n"); got != 10.5 {
+		t.Errorf("fractional cap: got=%#v, want=10.5", got)
+	}
+
+	m.AddFloat32("f", 1.25)
+	m.AddFloat32("f", 1.25)
+	if got := m.Get("f"); got != float32(2.5) {
+		t.Errorf("AddFloat32: got=%#v, want=float32(2.5)", got)
+	}
+	m.Set("i", 2)
+	m.AddFloat32("i", 1)
+	if got := m.Get("i"); got != 3 {
+		t.Errorf("AddFloat32 to int: got=%#v, want=3", got)
+	}
+}
+
+func TestGetTime(t *testing.T) {
+	when := time.Date(2024, 5, 6, 7, 8, 9, 500000000, time.UTC)
+	m := New()
+	m.Set("time", when)
+	m.Set("text", when.Format(time.RFC3339Nano))
+	m.Set("secs", when.Unix())
+	m.Set("fsecs", float64(when.UnixNano())/1e9)
+	m.Set("bad", "yesterday")
+	m.AddDuration("dur", time.Second)
+	for _, k := range []string{"time", "text"} {
+		if got, err := m.GetTime(k); err != nil || !got.Equal(when) {
+			t.Errorf("%q: got=%v, %v, want=%v", k, got, err, when)
+		}
+	}
+	if got, err := m.GetTime("secs"); err != nil || !got.Equal(when.Truncate(time.Second)) {
+		t.Errorf("secs: got=%v, %v", got, err)
+	}
+	if got, err := m.GetTime("fsecs"); err != nil || got.Sub(when).Abs() > time.Microsecond {
+		t.Errorf("fsecs: got=%v, %v", got, err)
+	}
+	for _, k := range []string{"bad", "dur", "missing"} {
+		if _, err := m.GetTime(k); err != ErrNotTime {
+			t.Errorf("%q: got err=%v, want=%v", k, err, ErrNotTime)
+		}
+	}
+	lines := strings.Split(string(m.DumpMDTable()), "\n")
+	if got, want := lines[7], "time | 2024-05-06T07:08:09.5Z"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestDumpMDTableLive(t *testing.T) {
+	m := New()
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprint("k", i), i)
+	}
+	m.Set("f", 0.25)
+	m.SetFormatter("k7", func(interface{}) string { return "seven" })
+	f := m.Freeze()
+	want, err := MDTable(f, f.When(), nil)
+	if err != nil {
+		t.Fatalf("MDTable failed: %v", err)
+	}
+	got := m.DumpMDTable()
+	strip := func(d []byte) string {
+		return string(d[bytes.IndexByte(d, '\n'):])
+	}
+	if strip(got) != strip(want) {
+		t.Errorf("live table differs:\n%s\nfrom:\n%s", got, want)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	m := New()
+	m.Set("old", 1)
+	detail := map[string]interface{}{"a": 1, "b": "two"}
+	if err := m.Replace(detail); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	detail["c"] = 3
+	if got, want := strings.Join(m.Keys(), ","), "a,b"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+	if _, ok := m.LastUpdated("old"); ok {
+		t.Error("replaced metric still has an update time")
+	}
+	if _, ok := m.LastUpdated("a"); !ok {
+		t.Error("new metric has no update time")
+	}
+}
+
+func TestRateUnordered(t *testing.T) {
+	now := time.Now()
+	vs := []struct {
+		pts  []Sample
+		want float64
+	}{
+		{
+			pts:  []Sample{{When: now.Add(2 * time.Second), Value: 3}, {When: now, Value: 1}},
+			want: 1,
+		},
+		{
+			pts:  []Sample{{When: now, Value: 1}, {When: now, Value: 2}},
+			want: 0,
+		},
+		{
+			pts:  []Sample{{When: now, Value: 1}, {When: now, Value: 2}, {When: now.Add(time.Second), Value: 5}},
+			want: 3,
+		},
+		{
+			pts:  []Sample{{When: now.Add(4 * time.Second), Value: 9}, {When: now, Value: 1}, {When: now.Add(time.Second), Value: 4}},
+			want: 2,
+		},
+	}
+	for i, v := range vs {
+		got := Rate(v.pts...)
+		if got != v.want {
+			t.Errorf("[%d] got=%f, want=%f", i, got, v.want)
+		}
+	}
+	if pts := vs[0].pts; !pts[0].When.After(pts[1].When) {
+		t.Error("Rate reordered the caller's samples")
+	}
+}
+
+func TestAccumulate(t *testing.T) {
+	m := New()
+	m.Set("200", 10)
+	m.Accumulate([]string{"200", "404", "200", "500", "200"})
+	if got, want := fmt.Sprint(m.Detail), "map[200:13 404:1 500:1]"; got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
+
+func TestNilValues(t *testing.T) {
+	m := New()
+	m.Set("a", 1)
+	m.Set("n", nil)
+	lines := strings.Split(string(m.DumpMDTable()), "\n")
+	if got, want := lines[3], "n | "; got != want {
+		t.Errorf("markdown: got=%q, want=%q", got, want)
+	}
+	d, err := MDTable(m.Freeze(), time.Now(), nil)
+	if err != nil {
+		t.Fatalf("MDTable failed: %v", err)
+	}
+	if got, want := strings.Split(string(d), "\n")[3], "n | "; got != want {
+		t.Errorf("frozen markdown: got=%q, want=%q", got, want)
+	}
+	if got, want := m.String(), `{"a":1,"n":null}`; got != want {
+		t.Errorf("JSON: got=%s, want=%s", got, want)
+	}
+	if d, err := m.SnapshotJSON(); err != nil || !strings.HasSuffix(string(d), `"values":{"a":1,"n":null}}`) {
+		t.Errorf("snapshot JSON: got=%s, %v", d, err)
+	}
+	if got, want := fmt.Sprint(m.Floats()), "map[a:1]"; got != want {
+		t.Errorf("Floats: got=%s, want=%s", got, want)
+	}
+	if got := m.Types()["n"]; got != "nil" {
+		t.Errorf("Types: got=%q, want=\"nil\"", got)
+	}
+	m.Export(func(k string, v interface{}, isNumber bool, _ float64) error {
+		if k == "n" && (v != nil || isNumber) {
+			t.Errorf("Export: got=%v, %v", v, isNumber)
+		}
+		return nil
+	})
+}
+
+func BenchmarkDumpMDTable(b *testing.B) {
+	m := New()
+	for i := 0; i < 10000; i++ {
+		m.Set(fmt.Sprint("metric.", i), i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.DumpMDTable()
+	}
+}
+
+func TestDeleteResetClear(t *testing.T) {
+	m := New()
+	m.Set("hits", int64(5))
+	m.Set("name", "x")
+	m.SetBytes("size", 1024)
+	if err := m.Reset("hits"); err != nil {
+		t.Errorf("Reset failed: %v", err)
+	}
+	if got := m.Get("hits"); got != int64(0) {
+		t.Errorf("reset: got=%#v, want=int64(0)", got)
+	}
+	if err := m.Reset("name"); !errors.Is(err, ErrNotNumber) {
+		t.Errorf("reset string: got=%v, want=%v", err, ErrNotNumber)
+	}
+	if err := m.Reset("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("reset missing: got=%v, want=%v", err, ErrNotFound)
+	}
+	if !m.Delete("size") || m.Delete("size") {
+		t.Error("Delete did not report presence")
+	}
+	if _, ok := m.GetMeta("size"); ok {
+		t.Error("meta of deleted metric retained")
+	}
+	if got, want := m.Keys(), []string{"hits", "name"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("keys: got=%q, want=%q", got, want)
+	}
+	m.Clear()
+	if got := m.Keys(); len(got) != 0 {
+		t.Errorf("cleared: got=%q", got)
+	}
+	m.Inc("hits")
+	if got := m.Get("hits"); got != 1.0 {
+		t.Errorf("after clear: got=%v, want=1", got)
+	}
+}
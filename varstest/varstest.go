@@ -0,0 +1,55 @@
+// Package varstest provides helpers for asserting the values of
+// vars.Metrics in tests.
+package varstest
+
+import (
+	"reflect"
+	"testing"
+
+	"zappem.net/pub/debug/vars"
+)
+
+// AssertNumber reports a test error unless metric key of m is a
+// number equal to want. It returns whether the assertion held.
+func AssertNumber(t testing.TB, m *vars.Metrics, key string, want float64) bool {
+	t.Helper()
+	got, state := m.GetNumberState(key)
+	switch state {
+	case vars.NumMissing:
+		t.Errorf("metric %q is absent, want=%g", key, want)
+		return false
+	case vars.NumNotNumber:
+		t.Errorf("metric %q = %#v is not a number, want=%g", key, m.Get(key), want)
+		return false
+	}
+	if got != want {
+		t.Errorf("metric %q: got=%g, want=%g", key, got, want)
+		return false
+	}
+	return true
+}
+
+// AssertValue reports a test error unless metric key of m is equal
+// to want, including its type, as compared by reflect.DeepEqual. It
+// returns whether the assertion held.
+func AssertValue(t testing.TB, m *vars.Metrics, key string, want interface{}) bool {
+	t.Helper()
+	if got := m.Get(key); !reflect.DeepEqual(got, want) {
+		t.Errorf("metric %q: got=%#v (%T), want=%#v (%T)", key, got, got, want, want)
+		return false
+	}
+	return true
+}
+
+// AssertAbsent reports a test error if metric key of m is present,
+// even with a nil value. It returns whether the assertion held.
+func AssertAbsent(t testing.TB, m *vars.Metrics, key string) bool {
+	t.Helper()
+	for _, k := range m.Keys() {
+		if k == key {
+			t.Errorf("metric %q is present with value %#v", key, m.Get(key))
+			return false
+		}
+	}
+	return true
+}
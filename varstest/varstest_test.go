@@ -0,0 +1,58 @@
+package varstest
+
+import (
+	"fmt"
+	"testing"
+
+	"zappem.net/pub/debug/vars"
+)
+
+// recorder is a testing.TB that records the errors reported to it.
+type recorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	m := vars.New()
+	m.Set("n", 3)
+	m.Set("s", "text")
+	m.Set("nil", nil)
+	m.Set("map", map[string]interface{}{"a": 1.0})
+	vs := []struct {
+		assert func(testing.TB) bool
+		err    string
+	}{
+		{assert: func(t testing.TB) bool { return AssertNumber(t, m, "n", 3) }},
+		{assert: func(t testing.TB) bool { return AssertNumber(t, m, "n", 4) }, err: `metric "n": got=3, want=4`},
+		{assert: func(t testing.TB) bool { return AssertNumber(t, m, "s", 4) }, err: `metric "s" = "text" is not a number, want=4`},
+		{assert: func(t testing.TB) bool { return AssertNumber(t, m, "x", 4) }, err: `metric "x" is absent, want=4`},
+		{assert: func(t testing.TB) bool { return AssertNumber(t, m, "nil", 4) }, err: `metric "nil" = <nil> is not a number, want=4`},
+		{assert: func(t testing.TB) bool { return AssertValue(t, m, "n", 3) }},
+		{assert: func(t testing.TB) bool { return AssertValue(t, m, "n", 3.0) }, err: `metric "n": got=3 (int), want=3 (float64)`},
+		{assert: func(t testing.TB) bool { return AssertValue(t, m, "map", map[string]interface{}{"a": 1.0}) }},
+		{assert: func(t testing.TB) bool { return AssertValue(t, m, "map", map[string]interface{}{"a": 2.0}) }, err: `metric "map": got=map[string]interface {}{"a":1} (map[string]interface {}), want=map[string]interface {}{"a":2} (map[string]interface {})`},
+		{assert: func(t testing.TB) bool { return AssertAbsent(t, m, "x") }},
+		{assert: func(t testing.TB) bool { return AssertAbsent(t, m, "nil") }, err: `metric "nil" is present with value <nil>`},
+	}
+	for i, v := range vs {
+		r := &recorder{TB: t}
+		ok := v.assert(r)
+		if ok != (v.err == "") {
+			t.Errorf("[%d] got ok=%v, errors=%q", i, ok, r.errs)
+			continue
+		}
+		if v.err == "" {
+			continue
+		}
+		if len(r.errs) != 1 || r.errs[0] != v.err {
+			t.Errorf("[%d] got errors=%q, want=%q", i, r.errs, v.err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package vars
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WindowCounter counts the events of a sliding time window, for
+// example, the last minute, in a metric. Unlike a plain counter, the
+// count decreases as old events age out of the window. The metric
+// holds the WindowCounter itself, and its value is computed each time
+// it is read, as for SetFunc, so it is always the count of the current
+// window.
+type WindowCounter struct {
+	mu     sync.Mutex
+	m      *Metrics
+	k      string
+	bucket time.Duration
+	// counts[i] is the number of events of the bucket numbered
+	// slots[i], where bucket numbers count bucket durations since
+	// the epoch.
+	counts []int64
+	slots  []int64
+	// last is the UnixNano time of the most recent event, and
+	// detached is set once the metric no longer holds w.
+	last     atomic.Int64
+	detached atomic.Bool
+
+	// now is the clock used to place events in buckets.
+	now func() time.Time
+}
+
+// NewWindowCounter returns a WindowCounter for metric k of m, which
+// counts the events of the last window, in buckets of the given
+// duration. Events expire from the count a bucket at a time. A
+// non-positive window is treated as a minute, and a non-positive
+// bucket as the whole window. A window shorter than a bucket is
+// treated as a single bucket. If m is nil, the WindowCounter only
+// tracks its own count.
+func NewWindowCounter(m *Metrics, k string, window, bucket time.Duration) *WindowCounter {
+	if window <= 0 {
+		window = time.Minute
+	}
+	if bucket <= 0 {
+		bucket = window
+	}
+	n := int(window / bucket)
+	if n < 1 {
+		n = 1
+	}
+	w := &WindowCounter{
+		m:      m,
+		k:      k,
+		bucket: bucket,
+		counts: make([]int64, n),
+		slots:  make([]int64, n),
+		now:    time.Now,
+	}
+	w.attach()
+	return w
+}
+
+// attach sets the metric of w to hold w.
+func (w *WindowCounter) attach() {
+	w.detached.Store(false)
+	if w.m.Set(w.k, w) != nil {
+		w.detached.Store(true)
+	}
+}
+
+// detach marks w as no longer held by its metric.
+func (w *WindowCounter) detach() {
+	w.detached.Store(true)
+}
+
+// compute returns the value of the metric of w.
+func (w *WindowCounter) compute() interface{} {
+	return w.Count()
+}
+
+// lastActive returns the time of the most recent event.
+func (w *WindowCounter) lastActive() time.Time {
+	if n := w.last.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// String formats the count of the window.
+func (w *WindowCounter) String() string {
+	return strconv.FormatInt(w.Count(), 10)
+}
+
+// Inc counts an event.
+func (w *WindowCounter) Inc() {
+	w.Add(1)
+}
+
+// Add counts n events. It also marks the metric as updated, see
+// LastUpdated, and restores it if it was deleted or replaced.
+func (w *WindowCounter) Add(n int64) {
+	w.mu.Lock()
+	now := w.now()
+	slot := now.UnixNano() / int64(w.bucket)
+	i := int(slot % int64(len(w.counts)))
+	if w.slots[i] != slot {
+		w.slots[i], w.counts[i] = slot, 0
+	}
+	w.counts[i] += n
+	w.mu.Unlock()
+	w.last.Store(now.UnixNano())
+	if w.detached.Load() {
+		w.attach()
+	}
+}
+
+// Count returns the number of events of the window.
+func (w *WindowCounter) Count() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.total(w.slot())
+}
+
+// slot returns the number of the current bucket.
+func (w *WindowCounter) slot() int64 {
+	return w.now().UnixNano() / int64(w.bucket)
+}
+
+// total returns the number of events in the window ending with
+// bucket number slot. The caller must hold w.mu.
+func (w *WindowCounter) total(slot int64) int64 {
+	var total int64
+	for i, s := range w.slots {
+		if s > slot-int64(len(w.slots)) && s <= slot {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
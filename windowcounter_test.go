@@ -0,0 +1,73 @@
+package vars
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWindowCounter(t *testing.T) {
+	m := New()
+	w := NewWindowCounter(m, "events", time.Minute, time.Second)
+	now := time.Unix(1000, 0)
+	w.now = func() time.Time { return now }
+	vs := []struct {
+		dt   time.Duration
+		n    int64
+		want int64
+	}{
+		{0, 2, 2},
+		{30 * time.Second, 1, 3},
+		{59 * time.Second, 1, 4},
+		{60 * time.Second, 0, 2},
+		{90 * time.Second, 0, 1},
+		{5 * time.Minute, 0, 0},
+	}
+	for i, v := range vs {
+		now = time.Unix(1000, 0).Add(v.dt)
+		if v.n != 0 {
+			w.Add(v.n)
+		}
+		if got := m.Get("events"); got != v.want {
+			t.Errorf("[%d] metric: got=%v, want=%d", i, got, v.want)
+		}
+		if got := w.Count(); got != v.want {
+			t.Errorf("[%d] got=%d, want=%d", i, got, v.want)
+		}
+	}
+	if got := m.Snap().Values.Get("events"); got != int64(0) {
+		t.Errorf("snapshot: got=%v, want=0", got)
+	}
+}
+
+func TestWindowCounterDefaults(t *testing.T) {
+	for _, v := range []struct{ window, bucket time.Duration }{{0, 0}, {time.Minute, 0}, {-time.Second, -time.Second}} {
+		m := New()
+		w := NewWindowCounter(m, "events", v.window, v.bucket)
+		w.Inc()
+		if got := m.Get("events"); got != int64(1) {
+			t.Errorf("%v: got=%v, want=1", v, got)
+		}
+	}
+}
+
+func TestWindowCounterAttach(t *testing.T) {
+	m := New()
+	w := NewWindowCounter(m, "events", time.Minute, time.Second)
+	now := time.Now().Add(time.Hour)
+	w.now = func() time.Time { return now }
+	w.Inc()
+	if updated, ok := m.LastUpdated("events"); !ok || !updated.Equal(now) {
+		t.Errorf("LastUpdated: got=%v, %v, want=%v", updated, ok, now)
+	}
+	m.Delete("events")
+	w.Inc()
+	if got := m.Get("events"); got != int64(2) {
+		t.Errorf("after Delete: got=%v, want=2", got)
+	}
+	m.Set("events", "text")
+	w.Inc()
+	if got, want := fmt.Sprint(m.Detail), "map[events:3]"; got != want {
+		t.Errorf("after Set: got=%s, want=%s", got, want)
+	}
+}